@@ -0,0 +1,146 @@
+// config/calendar.go
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "strings"
+    "time"
+)
+
+// Calendar-aware point rules, loaded from a local JSON calendar file.
+type Calendar struct {
+    WeekendPoints uint       `json:"weekendPoints"`
+    Holidays      []Holiday  `json:"holidays"`
+    DayRanges     []DayRange `json:"dayRanges"`
+}
+
+// Holiday is either fixed (Month + Day) or floating (Month + Weekday + Week).
+// Week counts from 1; -1 means the last such weekday of the month.
+type Holiday struct {
+    Name    string `json:"name"`
+    Month   int    `json:"month"`
+    Day     int    `json:"day,omitempty"`
+    Weekday string `json:"weekday,omitempty"`
+    Week    int    `json:"week,omitempty"`
+    Points  uint   `json:"points"`
+}
+
+// DayRange awards points for an inclusive day-of-month range.
+type DayRange struct {
+    Name   string `json:"name"`
+    From   int    `json:"from"`
+    To     int    `json:"to"`
+    Points uint   `json:"points"`
+}
+
+// CalendarMatch is a single calendar rule that applied to a date.
+type CalendarMatch struct {
+    Rule   string
+    Points uint
+}
+
+// ActiveCalendar is used when scoring receipts; empty by default so no calendar points are awarded.
+var ActiveCalendar = Calendar{}
+
+var weekdays = map[string]time.Weekday{
+    "sunday":    time.Sunday,
+    "monday":    time.Monday,
+    "tuesday":   time.Tuesday,
+    "wednesday": time.Wednesday,
+    "thursday":  time.Thursday,
+    "friday":    time.Friday,
+    "saturday":  time.Saturday,
+}
+
+func LoadCalendar(path string) (Calendar, error) {
+    var calendar Calendar
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return calendar, fmt.Errorf("error reading calendar %s: %v", path, err)
+    }
+    if err := json.Unmarshal(data, &calendar); err != nil {
+        return calendar, fmt.Errorf("error parsing calendar %s: %v", path, err)
+    }
+    if err := calendar.Validate(); err != nil {
+        return calendar, fmt.Errorf("invalid calendar %s: %v", path, err)
+    }
+    return calendar, nil
+}
+
+func (c Calendar) Validate() error {
+    for _, holiday := range c.Holidays {
+        if holiday.Name == "" {
+            return fmt.Errorf("holiday name cannot be empty")
+        }
+        if holiday.Month < 1 || holiday.Month > 12 {
+            return fmt.Errorf("holiday %s: invalid month %d", holiday.Name, holiday.Month)
+        }
+        if holiday.Weekday == "" {
+            // fixed date; Feb 29 is allowed and only matches in leap years
+            if holiday.Day < 1 || holiday.Day > getDaysInMonth(2000, holiday.Month) {
+                return fmt.Errorf("holiday %s: invalid day %d", holiday.Name, holiday.Day)
+            }
+            continue
+        }
+        if _, ok := weekdays[strings.ToLower(holiday.Weekday)]; !ok {
+            return fmt.Errorf("holiday %s: invalid weekday %s", holiday.Name, holiday.Weekday)
+        }
+        if holiday.Day != 0 {
+            return fmt.Errorf("holiday %s: day cannot be combined with weekday", holiday.Name)
+        }
+        if holiday.Week != -1 && (holiday.Week < 1 || holiday.Week > 5) {
+            return fmt.Errorf("holiday %s: week must be 1-5 or -1 (last)", holiday.Name)
+        }
+    }
+    for _, dayRange := range c.DayRanges {
+        if dayRange.From < 1 || dayRange.To > 31 || dayRange.From > dayRange.To {
+            return fmt.Errorf("day range %s: invalid range %d-%d", dayRange.Name, dayRange.From, dayRange.To)
+        }
+    }
+    return nil
+}
+
+// Match returns every calendar rule that applies to a normalized (YYYY-MM-DD) date.
+func (c Calendar) Match(dateStr string) []CalendarMatch {
+    date, err := time.Parse("2006-01-02", dateStr)
+    if err != nil {
+        return nil
+    }
+
+    var matches []CalendarMatch
+    if c.WeekendPoints > 0 && (date.Weekday() == time.Saturday || date.Weekday() == time.Sunday) {
+        matches = append(matches, CalendarMatch{Rule: "weekend", Points: c.WeekendPoints})
+    }
+    for _, holiday := range c.Holidays {
+        if holiday.matches(date) {
+            matches = append(matches, CalendarMatch{Rule: holiday.Name, Points: holiday.Points})
+        }
+    }
+    for _, dayRange := range c.DayRanges {
+        if date.Day() >= dayRange.From && date.Day() <= dayRange.To {
+            matches = append(matches, CalendarMatch{Rule: dayRange.Name, Points: dayRange.Points})
+        }
+    }
+    return matches
+}
+
+func (h Holiday) matches(date time.Time) bool {
+    if int(date.Month()) != h.Month {
+        return false
+    }
+    if h.Weekday == "" {
+        return date.Day() == h.Day
+    }
+
+    weekday, ok := weekdays[strings.ToLower(h.Weekday)]
+    if !ok || date.Weekday() != weekday {
+        return false
+    }
+    if h.Week == -1 {
+        // last occurrence: one week later falls in the next month
+        return date.Day()+7 > getDaysInMonth(date.Year(), h.Month)
+    }
+    return (date.Day()-1)/7+1 == h.Week
+}
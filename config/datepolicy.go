@@ -0,0 +1,83 @@
+// config/datepolicy.go
+package config
+
+import (
+    "fmt"
+    "time"
+)
+
+// DatePolicy bounds how far a purchase may lie from "now".
+// A zero duration disables that bound.
+type DatePolicy struct {
+    MaxFuture time.Duration // how far in the future a purchase may be
+    MaxAge    time.Duration // submission window for old receipts
+    Now       func() time.Time
+}
+
+// ActiveDatePolicy is applied by receipt validation; main.go overrides it from flags.
+var ActiveDatePolicy = DatePolicy{
+    MaxFuture: 15 * time.Minute,
+}
+
+// Without an offset a wall-clock time could be in any zone from UTC-12 to UTC+14
+const (
+    westmostOffset = -12 * time.Hour
+    eastmostOffset = 14 * time.Hour
+)
+
+const (
+    BoundMaxFuture = "maxFuture"
+    BoundMaxAge    = "maxAge"
+)
+
+// DatePolicyError reports which bound of the policy a purchase violated.
+type DatePolicyError struct {
+    Bound string
+    Limit time.Duration
+    Value string
+}
+
+func (e *DatePolicyError) Error() string {
+    if e.Bound == BoundMaxFuture {
+        return fmt.Sprintf("purchase %s is more than %v in the future (%s)", e.Value, e.Limit, e.Bound)
+    }
+    return fmt.Sprintf("purchase %s is older than the %v submission window (%s)", e.Value, e.Limit, e.Bound)
+}
+
+// Check validates a normalized date (YYYY-MM-DD) and time (HH:MM).
+// offset is the "-07:00" style offset when known, or "" to allow for any time zone.
+func (p DatePolicy) Check(dateStr, timeStr, offset string) error {
+    if p.MaxFuture <= 0 && p.MaxAge <= 0 {
+        return nil
+    }
+
+    wall, err := time.Parse("2006-01-02 15:04", dateStr+" "+timeStr)
+    if err != nil {
+        return fmt.Errorf("error parsing purchase %s %s: %v", dateStr, timeStr, err)
+    }
+
+    // earliest/latest instant the wall clock can correspond to
+    earliest, latest := wall.Add(-eastmostOffset), wall.Add(-westmostOffset)
+    value := dateStr + " " + timeStr
+    if offset != "" {
+        zoned, err := time.Parse("2006-01-02 15:04 -07:00", value+" "+offset)
+        if err != nil {
+            return fmt.Errorf("error parsing purchase offset %s: %v", offset, err)
+        }
+        earliest, latest = zoned, zoned
+        value += offset
+    }
+
+    now := time.Now()
+    if p.Now != nil {
+        now = p.Now()
+    }
+
+    if p.MaxFuture > 0 && earliest.After(now.Add(p.MaxFuture)) {
+        return &DatePolicyError{Bound: BoundMaxFuture, Limit: p.MaxFuture, Value: value}
+    }
+    if p.MaxAge > 0 && latest.Before(now.Add(-p.MaxAge)) {
+        return &DatePolicyError{Bound: BoundMaxAge, Limit: p.MaxAge, Value: value}
+    }
+    return nil
+}
@@ -49,9 +49,22 @@ var DateFormats = []DateFormat{
     },
 }
 
+// ParsedDate is a normalized (YYYY-MM-DD) date plus the DateFormat that matched the input.
+type ParsedDate struct {
+    Value  string
+    Format string
+    // Alternative is set when another format reads the input as a different valid date
+    Alternative string
+}
+
 func ValidateAndFormatDate(dateStr string) (string, error) {
+    parsed, err := ParseDate(dateStr)
+    return parsed.Value, err
+}
+
+func ParseDate(dateStr string) (ParsedDate, error) {
     if dateStr == "" {
-        return "", fmt.Errorf("date cannot be empty")
+        return ParsedDate{}, fmt.Errorf("date cannot be empty")
     }
 
     // Clean input
@@ -60,25 +73,35 @@ func ValidateAndFormatDate(dateStr string) (string, error) {
     // Already in ISO format
     if DateFormats[0].Pattern.MatchString(dateStr) {
         if isValidDate(dateStr) {
-            return dateStr, nil
+            return ParsedDate{Value: dateStr, Format: DateFormats[0].Description}, nil
         }
-        return "", fmt.Errorf("invalid date components: %s", dateStr)
+        return ParsedDate{}, fmt.Errorf("invalid date components: %s", dateStr)
     }
 
     // Try parsing with other formats
+    var result ParsedDate
     for _, format := range DateFormats {
         if format.Pattern.MatchString(dateStr) {
             parsedDate, err := time.Parse(format.Layout, dateStr)
             if err == nil {
                 isoDate := parsedDate.Format("2006-01-02")
-                if isValidDate(isoDate) {
-                    return isoDate, nil
+                if !isValidDate(isoDate) {
+                    continue
+                }
+                // first match wins; a later match only flags the ambiguity (e.g. US vs UK)
+                if result.Value == "" {
+                    result = ParsedDate{Value: isoDate, Format: format.Description}
+                } else if isoDate != result.Value && result.Alternative == "" {
+                    result.Alternative = isoDate
                 }
             }
         }
     }
+    if result.Value != "" {
+        return result, nil
+    }
 
-    return "", fmt.Errorf("unable to parse date: %s", dateStr)
+    return ParsedDate{}, fmt.Errorf("unable to parse date: %s", dateStr)
 }
 
 func isValidDate(dateStr string) bool {
@@ -164,30 +187,56 @@ var TimeFormats = []TimeFormat{
     },
 }
 
+// ParsedTime is a normalized (HH:MM) time plus the TimeFormat that matched the input.
+type ParsedTime struct {
+    Value  string
+    Format string
+    // SecondsDropped is set when the input had seconds, which HH:MM cannot keep
+    SecondsDropped bool
+}
+
 func ValidateAndFormatTime(timeStr string) (string, error) {
+    parsed, err := ParseTime(timeStr)
+    return parsed.Value, err
+}
+
+func ParseTime(timeStr string) (ParsedTime, error) {
     if timeStr == "" {
-        return "", fmt.Errorf("time cannot be empty")
+        return ParsedTime{}, fmt.Errorf("time cannot be empty")
     }
 
     // Clean input
     timeStr = strings.TrimSpace(timeStr)
     timeStr = strings.ToUpper(timeStr)
 
+    // Remember the submitted format before seconds are stripped
+    inputFormat := ""
+    for _, format := range TimeFormats {
+        if format.Pattern.MatchString(timeStr) {
+            inputFormat = format.Description
+            break
+        }
+    }
+
     // Remove seconds if present
+    secondsDropped := false
     if strings.Count(timeStr, ":") == 2 {
         parts := strings.Split(timeStr, ":")
         timeStr = parts[0] + ":" + parts[1]
-        if strings.Contains(timeStr, "AM") || strings.Contains(timeStr, "PM") {
-            timeStr += strings.Split(parts[2], " ")[1]
-        }
+        // keep the AM/PM suffix, with or without a space before it
+        timeStr += strings.TrimLeft(parts[2], "0123456789")
+        secondsDropped = true
     }
 
     // Already in 24-hour format
     if TimeFormats[0].Pattern.MatchString(timeStr) {
         if isValidTime(timeStr) {
-            return timeStr, nil
+            if inputFormat == "" {
+                inputFormat = TimeFormats[0].Description
+            }
+            return ParsedTime{Value: timeStr, Format: inputFormat, SecondsDropped: secondsDropped}, nil
         }
-        return "", fmt.Errorf("invalid time components: %s", timeStr)
+        return ParsedTime{}, fmt.Errorf("invalid time components: %s", timeStr)
     }
 
     // Try parsing with other formats
@@ -195,12 +244,15 @@ func ValidateAndFormatTime(timeStr string) (string, error) {
         if format.Pattern != nil && format.Pattern.MatchString(timeStr) {
             parsedTime, err := time.Parse(format.Layout, timeStr)
             if err == nil {
-                return parsedTime.Format("15:04"), nil
+                if inputFormat == "" {
+                    inputFormat = format.Description
+                }
+                return ParsedTime{Value: parsedTime.Format("15:04"), Format: inputFormat, SecondsDropped: secondsDropped}, nil
             }
         }
     }
 
-    return "", fmt.Errorf("unable to parse time: %s", timeStr)
+    return ParsedTime{}, fmt.Errorf("unable to parse time: %s", timeStr)
 }
 
 func isValidTime(timeStr string) bool {
@@ -234,4 +286,39 @@ func IsTimeInRange(timeStr, startTime, endTime string) (bool, error) {
     }
 
     return t.After(start) && t.Before(end), nil
-}
\ No newline at end of file
+}
+
+// Timestamp-related utilities
+// ParsedTimestamp holds an RFC 3339 timestamp split into the normalized
+// date/time fields, expressed in the timestamp's own offset.
+type ParsedTimestamp struct {
+    Date   string
+    Time   string
+    Offset string
+}
+
+func ValidateAndFormatTimestamp(timestampStr string) (ParsedTimestamp, error) {
+    if timestampStr == "" {
+        return ParsedTimestamp{}, fmt.Errorf("timestamp cannot be empty")
+    }
+
+    // Clean input
+    timestampStr = strings.TrimSpace(timestampStr)
+
+    parsed, err := time.Parse(time.RFC3339, timestampStr)
+    if err != nil {
+        return ParsedTimestamp{}, fmt.Errorf("unable to parse timestamp (expected RFC 3339): %s", timestampStr)
+    }
+
+    // Keep the wall clock of the original offset rather than converting to UTC
+    isoDate := parsed.Format("2006-01-02")
+    if !isValidDate(isoDate) {
+        return ParsedTimestamp{}, fmt.Errorf("invalid date components: %s", timestampStr)
+    }
+
+    return ParsedTimestamp{
+        Date:   isoDate,
+        Time:   parsed.Format("15:04"),
+        Offset: parsed.Format("-07:00"),
+    }, nil
+}
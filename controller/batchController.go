@@ -0,0 +1,189 @@
+// controller/batchController.go
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"receipt-processor-challenge/model"
+	"strconv"
+)
+
+// MaxBatchSize caps how many receipts one POST /receipts/batch may carry.
+var MaxBatchSize = 10000
+
+// MaxBatchBytes caps the size of a POST /receipts/batch body.
+var MaxBatchBytes int64 = 64 << 20
+
+// receiptResult is the outcome for one receipt in a bulk submission:
+// an id (plus warnings) when accepted, otherwise the same code/errors
+// POST /receipts/process would have returned.
+type receiptResult struct {
+	Status   int                     `json:"status"`
+	ID       string                  `json:"id,omitempty"`
+	Warnings []model.Warning         `json:"warnings,omitempty"`
+	Code     string                  `json:"code,omitempty"`
+	Detail   string                  `json:"detail,omitempty"`
+	Errors   []model.ValidationError `json:"errors,omitempty"`
+	// OriginalID is set on a duplicate_receipt result
+	OriginalID string `json:"originalId,omitempty"`
+}
+
+// batchResult is a receiptResult in request order
+type batchResult struct {
+	Index int `json:"index"`
+	receiptResult
+}
+
+type batchResponse struct {
+	Atomic   bool          `json:"atomic"`
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Results  []batchResult `json:"results"`
+}
+
+// ProcessReceiptBatch validates and scores an array of receipts independently
+// (POST /receipts/batch). With ?atomic=true nothing is stored unless every
+// receipt is valid.
+func (h *Handler) ProcessReceiptBatch(w http.ResponseWriter, r *http.Request) {
+	profile, err := validationProfile(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	atomic := false
+	if value := r.URL.Query().Get("atomic"); value != "" {
+		if atomic, err = strconv.ParseBool(value); err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "atomic must be true or false")
+			return
+		}
+	}
+
+	items, p := decodeBatch(http.MaxBytesReader(w, r.Body, MaxBatchBytes))
+	if p != nil {
+		writeProblem(w, r, *p)
+		return
+	}
+	if len(items) == 0 {
+		writeError(w, r, http.StatusBadRequest, CodeEmptyBody, "batch must contain at least one receipt")
+		return
+	}
+
+	response := batchResponse{Atomic: atomic, Results: make([]batchResult, len(items))}
+	accepted := make([]model.Receipt, 0, len(items))
+	acceptedAt := make([]int, 0, len(items))
+	for i, item := range items {
+		receipt, result := processReceiptJSON(item, profile)
+		response.Results[i] = batchResult{Index: i, receiptResult: result}
+		if result.ID == "" {
+			response.Rejected++
+			continue
+		}
+		accepted, acceptedAt = append(accepted, receipt), append(acceptedAt, i)
+		response.Accepted++
+	}
+
+	status := http.StatusOK
+	if atomic && response.Rejected > 0 {
+		rollBackBatch(&response)
+		accepted = nil
+		status = http.StatusUnprocessableEntity
+	}
+
+	for j := range accepted {
+		result := &response.Results[acceptedAt[j]].receiptResult
+		if err := h.putReceipt(&accepted[j]); err != nil {
+			*result = problemResult(storeProblem(err))
+			response.Accepted--
+			response.Rejected++
+			if atomic {
+				// undo what this batch already stored
+				for _, stored := range accepted[:j] {
+					h.Store.Delete(stored.ID)
+				}
+				rollBackBatch(&response)
+				status = result.Status
+				break
+			}
+			continue
+		}
+		// the duplicate policy may have flagged it
+		result.Warnings = accepted[j].Warnings
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// decodeBatch reads the array one element at a time, each kept raw so it is
+// decoded on its own and one bad receipt doesn't sink the rest. It stops as
+// soon as the array has more than MaxBatchSize elements.
+func decodeBatch(body io.Reader) ([]json.RawMessage, *problem) {
+	fail := func(err error) *problem {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &problem{Status: http.StatusRequestEntityTooLarge, Code: CodeRequestTooLarge,
+				Detail: fmt.Sprintf("a batch can be at most %d bytes", tooLarge.Limit)}
+		}
+		p := decodeProblem(err)
+		return &p
+	}
+
+	decoder := json.NewDecoder(body)
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, fail(err)
+	}
+	if token != json.Delim('[') {
+		return nil, &problem{Status: http.StatusBadRequest, Code: CodeMalformedJSON, Detail: "a batch must be a JSON array of receipts"}
+	}
+	var items []json.RawMessage
+	for decoder.More() {
+		if len(items) == MaxBatchSize {
+			return nil, &problem{Status: http.StatusRequestEntityTooLarge, Code: CodeBatchTooLarge,
+				Detail: fmt.Sprintf("a batch can have at most %d receipts", MaxBatchSize)}
+		}
+		var item json.RawMessage
+		if err := decoder.Decode(&item); err != nil {
+			return nil, fail(err)
+		}
+		items = append(items, item)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, fail(err)
+	}
+	return items, nil
+}
+
+// processReceiptJSON decodes and ingests one receipt (without storing it)
+func processReceiptJSON(data []byte, profile model.ValidationProfile) (model.Receipt, receiptResult) {
+	receipt, err := decodeReceipt(bytes.NewReader(data))
+	if err != nil {
+		return receipt, problemResult(decodeProblem(err))
+	}
+	if err := ingestReceipt(&receipt, profile); err != nil {
+		return receipt, problemResult(ingestProblem(err))
+	}
+	return receipt, receiptResult{Status: http.StatusOK, ID: receipt.ID, Warnings: receipt.Warnings}
+}
+
+func problemResult(p problem) receiptResult {
+	return receiptResult{Status: p.Status, Code: p.Code, Detail: p.Detail, Errors: p.Errors, OriginalID: p.OriginalID}
+}
+
+// rollBackBatch marks every accepted receipt of an atomic batch as not stored;
+// no IDs are handed out
+func rollBackBatch(response *batchResponse) {
+	for i := range response.Results {
+		result := &response.Results[i]
+		if result.ID != "" {
+			result.Status, result.ID, result.Warnings = http.StatusFailedDependency, "", nil
+			result.Code, result.Detail = CodeBatchRolledBack, "valid, but not stored because another receipt in the atomic batch failed"
+		}
+	}
+	response.Accepted = 0
+}
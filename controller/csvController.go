@@ -0,0 +1,553 @@
+// controller/csvController.go
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"receipt-processor-challenge/model"
+	"strconv"
+	"strings"
+)
+
+// Canonical CSV column names; ?map=field:Header renames them per import.
+const (
+	csvReceiptRef       = "receiptRef"
+	csvRetailer         = "retailer"
+	csvPurchaseDate     = "purchaseDate"
+	csvPurchaseTime     = "purchaseTime"
+	csvPurchasedAt      = "purchasedAt"
+	csvTotal            = "total"
+	csvShortDescription = "shortDescription"
+	csvPrice            = "price"
+)
+
+var csvFields = []string{csvReceiptRef, csvRetailer, csvPurchaseDate, csvPurchaseTime, csvPurchasedAt, csvTotal, csvShortDescription, csvPrice}
+
+// receipt-level columns that must agree on every row of a flattened receipt
+var csvReceiptFields = []string{csvRetailer, csvPurchaseDate, csvPurchaseTime, csvPurchasedAt, csvTotal}
+
+// MaxCSVUploadSize bounds a multipart CSV upload held in memory.
+var MaxCSVUploadSize int64 = 32 << 20
+
+// errTooManyCSVRows is returned once a file has more than MaxBatchSize rows;
+// an import is held to the same MaxBatchSize and MaxBatchBytes as a batch.
+var errTooManyCSVRows = errors.New("too many rows")
+
+// csvMapping maps a canonical field to the header used in the uploaded file.
+type csvMapping map[string]string
+
+// csvFieldError points at a row (1-based CSV line) and, when known, a column.
+type csvFieldError struct {
+	File    string `json:"file"`
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// csvReceiptResult is the outcome for one receipt assembled from CSV rows.
+type csvReceiptResult struct {
+	Ref      string          `json:"ref,omitempty"`
+	Rows     []int           `json:"rows"`
+	Status   int             `json:"status"`
+	ID       string          `json:"id,omitempty"`
+	Warnings []model.Warning `json:"warnings,omitempty"`
+	Code     string          `json:"code,omitempty"`
+	Detail   string          `json:"detail,omitempty"`
+	Errors   []csvFieldError `json:"errors,omitempty"`
+	// OriginalID is set on a duplicate_receipt result
+	OriginalID string `json:"originalId,omitempty"`
+}
+
+type csvImportResponse struct {
+	Layout    string             `json:"layout"`
+	Accepted  int                `json:"accepted"`
+	Rejected  int                `json:"rejected"`
+	Results   []csvReceiptResult `json:"results"`
+	RowErrors []csvFieldError    `json:"rowErrors,omitempty"`
+}
+
+type csvRow struct {
+	line   int
+	record []string
+}
+
+// csvTable is a parsed CSV file with its header resolved to canonical fields.
+type csvTable struct {
+	file    string
+	header  []string
+	columns map[string]int
+	rows    []csvRow
+}
+
+// csvGroup is the rows that make up one receipt.
+type csvGroup struct {
+	ref        string
+	table      *csvTable // where receipt-level fields come from
+	receiptRow csvRow
+	itemTable  *csvTable
+	itemRows   []csvRow
+	conflicts  []csvFieldError
+}
+
+// ImportReceiptsCSV creates receipts from CSV (POST /receipts/import), through the
+// same validation and scoring as POST /receipts/process. Two layouts are accepted:
+//   - flattened: a text/csv body (or multipart "file") with one row per item and
+//     the receipt columns repeated, grouped by receiptRef or by identical receipt columns
+//   - linked: multipart "receipts" and "items" files joined on receiptRef
+func (h *Handler) ImportReceiptsCSV(w http.ResponseWriter, r *http.Request) {
+	profile, err := validationProfile(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	mapping, err := parseCSVMapping(r.URL.Query()["map"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	var groups []*csvGroup
+	var rowErrors []csvFieldError
+	response := csvImportResponse{Layout: "flattened"}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBatchBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(MaxCSVUploadSize); err != nil {
+			writeCSVError(w, r, fmt.Errorf("unable to read multipart upload: %w", err))
+			return
+		}
+		readPart := func(name string) (*csvTable, []csvFieldError, error) {
+			part, _, err := r.FormFile(name)
+			if err != nil {
+				return nil, nil, nil
+			}
+			defer part.Close()
+			return readCSVTable(name, part, mapping)
+		}
+
+		var receiptsTable, itemsTable, flatTable *csvTable
+		var errs []csvFieldError
+		if receiptsTable, errs, err = readPart("receipts"); err == nil {
+			rowErrors = append(rowErrors, errs...)
+			if itemsTable, errs, err = readPart("items"); err == nil {
+				rowErrors = append(rowErrors, errs...)
+				if flatTable, errs, err = readPart("file"); err == nil {
+					rowErrors = append(rowErrors, errs...)
+				}
+			}
+		}
+		if err != nil {
+			writeCSVError(w, r, err)
+			return
+		}
+
+		switch {
+		case receiptsTable != nil && itemsTable != nil:
+			response.Layout = "linked"
+			groups, errs, err = groupLinkedCSV(receiptsTable, itemsTable)
+		case flatTable != nil:
+			groups, err = groupFlattenedCSV(flatTable)
+		default:
+			err = errors.New(`multipart upload needs a "file" part (flattened) or "receipts" and "items" parts (linked)`)
+		}
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidCSV, err.Error())
+			return
+		}
+		rowErrors = append(rowErrors, errs...)
+	} else {
+		table, errs, err := readCSVTable("file", r.Body, mapping)
+		if err == nil {
+			groups, err = groupFlattenedCSV(table)
+		}
+		if err != nil {
+			writeCSVError(w, r, err)
+			return
+		}
+		rowErrors = append(rowErrors, errs...)
+	}
+
+	response.Results = make([]csvReceiptResult, 0, len(groups))
+	response.RowErrors = rowErrors
+	for _, group := range groups {
+		result := group.process(h, profile)
+		if result.ID != "" {
+			response.Accepted++
+		} else {
+			response.Rejected++
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeCSVError reports a file that cannot be imported: a 413 past the batch
+// limits, otherwise a 400
+func writeCSVError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, r, http.StatusRequestEntityTooLarge, CodeRequestTooLarge,
+			fmt.Sprintf("an import can be at most %d bytes", tooLarge.Limit))
+	case errors.Is(err, errTooManyCSVRows):
+		writeError(w, r, http.StatusRequestEntityTooLarge, CodeBatchTooLarge,
+			fmt.Sprintf("an import can have at most %d rows per file", MaxBatchSize))
+	default:
+		writeError(w, r, http.StatusBadRequest, CodeInvalidCSV, err.Error())
+	}
+}
+
+// parseCSVMapping reads ?map=field:Header pairs
+func parseCSVMapping(values []string) (csvMapping, error) {
+	mapping := csvMapping{}
+	for _, value := range values {
+		field, header, ok := strings.Cut(value, ":")
+		if !ok || header == "" {
+			return nil, fmt.Errorf("map must look like field:Header, got %q", value)
+		}
+		if !isCSVField(field) {
+			return nil, fmt.Errorf("map: unknown field %q (expected one of %s)", field, strings.Join(csvFields, ", "))
+		}
+		mapping[field] = header
+	}
+	return mapping, nil
+}
+
+func isCSVField(field string) bool {
+	for _, known := range csvFields {
+		if known == field {
+			return true
+		}
+	}
+	return false
+}
+
+// readCSVTable reads a header row and the records below it. Malformed records are
+// returned as row errors; only an unreadable header fails the whole file.
+func readCSVTable(file string, body io.Reader, mapping csvMapping) (*csvTable, []csvFieldError, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, fmt.Errorf("%s: CSV is empty; a header row is required", file)
+		}
+		return nil, nil, fmt.Errorf("%s: unable to read header row: %w", file, err)
+	}
+
+	table := &csvTable{file: file, header: header, columns: map[string]int{}}
+	for _, field := range csvFields {
+		name := field
+		if mapped, ok := mapping[field]; ok {
+			name = mapped
+		}
+		for i, column := range header {
+			if strings.EqualFold(strings.TrimSpace(column), name) {
+				table.columns[field] = i
+				break
+			}
+		}
+	}
+
+	var rowErrors []csvFieldError
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// FieldPos is only valid after a successful Read, so the line comes from the error
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, nil, fmt.Errorf("%s: unable to read CSV: %w", file, err)
+			}
+			rowErrors = append(rowErrors, csvFieldError{File: file, Row: parseErr.StartLine, Code: CodeInvalidCSV, Message: csvErrorMessage(err)})
+			continue
+		}
+		if len(table.rows) == MaxBatchSize {
+			return nil, nil, fmt.Errorf("%s: %w", file, errTooManyCSVRows)
+		}
+		line, _ := reader.FieldPos(0)
+		table.rows = append(table.rows, csvRow{line: line, record: record})
+	}
+	return table, rowErrors, nil
+}
+
+func csvErrorMessage(err error) string {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		if errors.Is(parseErr.Err, csv.ErrFieldCount) {
+			return "row has a different number of columns than the header"
+		}
+		return parseErr.Err.Error()
+	}
+	return err.Error()
+}
+
+func (t *csvTable) has(field string) bool {
+	_, ok := t.columns[field]
+	return ok
+}
+
+func (t *csvTable) value(row csvRow, field string) string {
+	if i, ok := t.columns[field]; ok && i < len(row.record) {
+		return row.record[i]
+	}
+	return ""
+}
+
+// columnName is the header the file actually used for a canonical field
+func (t *csvTable) columnName(field string) string {
+	if i, ok := t.columns[field]; ok {
+		return t.header[i]
+	}
+	return field
+}
+
+func (t *csvTable) require(fields ...string) error {
+	var missing []string
+	for _, field := range fields {
+		if !t.has(field) {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s: missing column(s) %s (use ?map=field:Header to map differently named columns)",
+			t.file, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (t *csvTable) requireDateColumns() error {
+	if t.has(csvPurchasedAt) {
+		return nil
+	}
+	return t.require(csvPurchaseDate, csvPurchaseTime)
+}
+
+// groupFlattenedCSV collects item rows into receipts: by receiptRef when the column
+// exists, otherwise consecutive rows with the same receipt columns.
+func groupFlattenedCSV(table *csvTable) ([]*csvGroup, error) {
+	if err := table.require(csvRetailer, csvTotal, csvShortDescription, csvPrice); err != nil {
+		return nil, err
+	}
+	if err := table.requireDateColumns(); err != nil {
+		return nil, err
+	}
+
+	byRef := table.has(csvReceiptRef)
+	var groups []*csvGroup
+	refs := map[string]*csvGroup{}
+	var current *csvGroup
+	for _, row := range table.rows {
+		var group *csvGroup
+		if byRef {
+			group = refs[table.value(row, csvReceiptRef)]
+		} else if current != nil && table.sameReceipt(current.receiptRow, row) {
+			group = current
+		}
+
+		if group == nil {
+			group = &csvGroup{ref: table.value(row, csvReceiptRef), table: table, receiptRow: row, itemTable: table}
+			groups = append(groups, group)
+			refs[group.ref] = group
+		} else {
+			// repeated receipt columns must agree with the receipt's first row
+			for _, field := range csvReceiptFields {
+				if table.has(field) && table.value(row, field) != table.value(group.receiptRow, field) {
+					group.conflicts = append(group.conflicts, csvFieldError{
+						File: table.file, Row: row.line, Column: table.columnName(field), Code: model.CodeConflict,
+						Message: fmt.Sprintf("%s differs from row %d of the same receipt", table.columnName(field), group.receiptRow.line),
+					})
+				}
+			}
+		}
+		group.itemRows = append(group.itemRows, row)
+		current = group
+	}
+	return groups, nil
+}
+
+func (t *csvTable) sameReceipt(a, b csvRow) bool {
+	for _, field := range csvReceiptFields {
+		if t.value(a, field) != t.value(b, field) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupLinkedCSV joins a receipts file and an items file on receiptRef
+func groupLinkedCSV(receipts, items *csvTable) ([]*csvGroup, []csvFieldError, error) {
+	if err := receipts.require(csvReceiptRef, csvRetailer, csvTotal); err != nil {
+		return nil, nil, err
+	}
+	if err := receipts.requireDateColumns(); err != nil {
+		return nil, nil, err
+	}
+	if err := items.require(csvReceiptRef, csvShortDescription, csvPrice); err != nil {
+		return nil, nil, err
+	}
+
+	var rowErrors []csvFieldError
+	var groups []*csvGroup
+	refs := map[string]*csvGroup{}
+	for _, row := range receipts.rows {
+		ref := receipts.value(row, csvReceiptRef)
+		if _, exists := refs[ref]; exists {
+			rowErrors = append(rowErrors, csvFieldError{File: receipts.file, Row: row.line, Column: receipts.columnName(csvReceiptRef),
+				Code: model.CodeConflict, Message: fmt.Sprintf("duplicate %s %q; row skipped", receipts.columnName(csvReceiptRef), ref)})
+			continue
+		}
+		group := &csvGroup{ref: ref, table: receipts, receiptRow: row, itemTable: items}
+		refs[ref] = group
+		groups = append(groups, group)
+	}
+	for _, row := range items.rows {
+		ref := items.value(row, csvReceiptRef)
+		group, ok := refs[ref]
+		if !ok {
+			rowErrors = append(rowErrors, csvFieldError{File: items.file, Row: row.line, Column: items.columnName(csvReceiptRef),
+				Code: CodeUnknownReceiptRef, Message: fmt.Sprintf("no receipt with %s %q; row skipped", items.columnName(csvReceiptRef), ref)})
+			continue
+		}
+		group.itemRows = append(group.itemRows, row)
+	}
+	return groups, rowErrors, nil
+}
+
+// process builds the receipt and runs it through ingestReceipt, mapping any
+// validation errors back to the CSV row and column they came from.
+func (g *csvGroup) process(h *Handler, profile model.ValidationProfile) csvReceiptResult {
+	result := csvReceiptResult{Ref: g.ref}
+	lines := map[int]bool{}
+	for _, row := range append([]csvRow{g.receiptRow}, g.itemRows...) {
+		if !lines[row.line] {
+			lines[row.line] = true
+			result.Rows = append(result.Rows, row.line)
+		}
+	}
+
+	if len(g.conflicts) > 0 {
+		result.Status, result.Code, result.Errors = http.StatusBadRequest, CodeInvalidReceipt, g.conflicts
+		result.Detail = "rows of the same receipt disagree on receipt columns"
+		return result
+	}
+
+	receipt := model.Receipt{
+		Retailer:     g.table.value(g.receiptRow, csvRetailer),
+		PurchaseDate: g.table.value(g.receiptRow, csvPurchaseDate),
+		PurchaseTime: g.table.value(g.receiptRow, csvPurchaseTime),
+		PurchasedAt:  g.table.value(g.receiptRow, csvPurchasedAt),
+		Total:        g.table.value(g.receiptRow, csvTotal),
+		Items:        []model.Item{},
+	}
+	for _, row := range g.itemRows {
+		receipt.Items = append(receipt.Items, model.Item{
+			ShortDescription: g.itemTable.value(row, csvShortDescription),
+			Price:            g.itemTable.value(row, csvPrice),
+		})
+	}
+
+	if err := ingestReceipt(&receipt, profile); err != nil {
+		p := ingestProblem(err)
+		result.Status, result.Code, result.Detail = p.Status, p.Code, p.Detail
+		for _, e := range p.Errors {
+			result.Errors = append(result.Errors, g.locate(e))
+		}
+		return result
+	}
+
+	if err := h.putReceipt(&receipt); err != nil {
+		p := storeProblem(err)
+		result.Status, result.Code, result.Detail, result.OriginalID = p.Status, p.Code, p.Detail, p.OriginalID
+		return result
+	}
+	result.Status, result.ID, result.Warnings = http.StatusOK, receipt.ID, receipt.Warnings
+	return result
+}
+
+// locate turns a JSON pointer like /items/2/price into the CSV row + column
+func (g *csvGroup) locate(e model.ValidationError) csvFieldError {
+	located := csvFieldError{File: g.table.file, Row: g.receiptRow.line, Code: e.Code, Message: e.Message}
+	parts := strings.Split(strings.TrimPrefix(e.Pointer, "/"), "/")
+	if len(parts) == 3 && parts[0] == "items" {
+		if i, err := strconv.Atoi(parts[1]); err == nil && i < len(g.itemRows) {
+			located.File, located.Row = g.itemTable.file, g.itemRows[i].line
+			located.Column = g.itemTable.columnName(parts[2])
+		}
+		return located
+	}
+	if len(parts) == 1 && isCSVField(parts[0]) {
+		located.Column = g.table.columnName(parts[0])
+	}
+	return located
+}
+
+// csvExportHeader is one row per item, with the receipt and its points breakdown repeated
+var csvExportHeader = []string{
+	"id", "retailer", "purchaseDate", "purchaseTime", "purchaseOffset", "total", "points",
+	"retailerNamePoints", "totalPoints", "itemPairPoints", "itemDescriptionPoints", "oddDayPoints", "calendarPoints", "purchaseTimePoints",
+	"itemIndex", "shortDescription", "price",
+}
+
+// receiptCSVRows exports the points breakdown stored when the receipt was
+// scored, so it always adds up to points
+func receiptCSVRows(receipt model.Receipt) [][]string {
+	breakdown := receipt.Breakdown
+	uintText := func(value uint) string { return strconv.FormatUint(uint64(value), 10) }
+	prefix := []string{
+		receipt.ID, csvText(receipt.Retailer), receipt.PurchaseDate, receipt.PurchaseTime, receipt.PurchaseOffset, receipt.Total, uintText(receipt.Points),
+		uintText(breakdown.RetailerName), uintText(breakdown.Total), uintText(breakdown.ItemPairs), uintText(breakdown.ItemDescriptions),
+		uintText(breakdown.OddDay), uintText(breakdown.Calendar), uintText(breakdown.PurchaseTime),
+	}
+
+	rows := make([][]string, 0, len(receipt.Items))
+	for i, item := range receipt.Items {
+		row := append(append([]string{}, prefix...), strconv.Itoa(i), csvText(item.ShortDescription), item.Price)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// csvText neutralizes free text a spreadsheet would run as a formula by
+// prefixing it with a single quote
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// exportReceiptsCSV streams stored receipts as flattened CSV
+func exportReceiptsCSV(w http.ResponseWriter, store model.ReceiptStore) {
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="receipts.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(csvExportHeader)
+	written := 0
+	store.Iterate(func(receipt model.Receipt) bool {
+		if err := writer.WriteAll(receiptCSVRows(receipt)); err != nil {
+			return false
+		}
+		if written++; written%exportFlushEvery == 0 {
+			controller.Flush()
+		}
+		return true
+	})
+	writer.Flush()
+	controller.Flush()
+}
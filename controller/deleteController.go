@@ -0,0 +1,144 @@
+// controller/deleteController.go
+package controller
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"receipt-processor-challenge/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AdminToken is the bearer token the /admin routes require; they are not
+// registered at all while it is empty. main.go sets it from flags.
+var AdminToken string
+
+// trashStore keeps deleted receipts restorable, such as model.SoftDeleteStore
+type trashStore interface {
+	SoftDelete(id string) (model.Receipt, bool, error)
+	Restore(id string) (model.Receipt, bool, error)
+	Deleted() []model.Receipt
+	PurgeAt(receipt model.Receipt) time.Time
+	TrashStats() model.TrashStats
+}
+
+// DeleteReceipt removes a receipt (DELETE /receipts/{id}). With a trashStore
+// the receipt is only hidden, its points voided, and an admin can restore it
+// until it is purged; any other store removes it at once.
+func (h *Handler) DeleteReceipt(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	// under h.edits so an edit in flight cannot store the receipt again
+	h.edits.Lock()
+	var found bool
+	var err error
+	if trash, ok := h.Store.(trashStore); ok {
+		_, found, err = trash.SoftDelete(id)
+	} else {
+		found, err = h.Store.Delete(id)
+	}
+	h.edits.Unlock()
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternalError, "the receipt could not be deleted")
+		return
+	}
+	if !found {
+		writeError(w, r, http.StatusNotFound, CodeReceiptNotFound, "Receipt not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreReceipt brings back a deleted receipt, with its points, before it is
+// purged (POST /admin/receipts/{id}/restore)
+func (h *Handler) RestoreReceipt(w http.ResponseWriter, r *http.Request) {
+	e, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	trash, ok := h.Store.(trashStore)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, CodeReceiptNotFound, "this store removes receipts at once; there is nothing to restore")
+		return
+	}
+
+	h.edits.Lock()
+	receipt, found, err := trash.Restore(r.PathValue("id"))
+	h.edits.Unlock()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternalError, "the receipt could not be restored")
+		return
+	}
+	if !found {
+		writeError(w, r, http.StatusNotFound, CodeReceiptNotFound, "No deleted receipt with that ID; it may never have existed or already be purged")
+		return
+	}
+
+	w.Header().Set("ETag", receipt.ETag())
+	if err := applyReceiptView(r, &receipt); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	writeNegotiated(w, r, e, "receipt", receipt)
+}
+
+// ListDeletedReceipts shows the receipts that can still be restored, most
+// recently deleted first (GET /admin/receipts/deleted)
+func (h *Handler) ListDeletedReceipts(w http.ResponseWriter, r *http.Request) {
+	e, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	response := deletedReceiptsResponse{Receipts: []deletedReceipt{}}
+	if trash, ok := h.Store.(trashStore); ok {
+		for _, receipt := range trash.Deleted() {
+			deleted := deletedReceipt{Receipt: receipt, DeletedAt: receipt.DeletedAt}
+			if purgeAt := trash.PurgeAt(receipt); !purgeAt.IsZero() {
+				deleted.PurgeAt = &purgeAt
+			}
+			if err := applyReceiptView(r, &deleted.Receipt); err != nil {
+				writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+				return
+			}
+			response.Receipts = append(response.Receipts, deleted)
+		}
+	}
+	sort.Slice(response.Receipts, func(i, j int) bool {
+		a, b := response.Receipts[i], response.Receipts[j]
+		if !a.DeletedAt.Equal(b.DeletedAt) {
+			return a.DeletedAt.After(b.DeletedAt)
+		}
+		return a.ID < b.ID
+	})
+	writeNegotiated(w, r, e, "receipts", response)
+}
+
+// requireAdmin answers 401 without an Authorization: Bearer token and 403 with
+// the wrong one
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "admin routes need an Authorization: Bearer token")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) != 1 {
+			writeError(w, r, http.StatusForbidden, CodeForbidden, "the admin token is not valid")
+			return
+		}
+		next(w, r)
+	}
+}
+
+type deletedReceipt struct {
+	model.Receipt
+	DeletedAt time.Time `json:"deletedAt" xml:"deletedAt"`
+	// PurgeAt is left out when deleted receipts are kept until restored
+	PurgeAt *time.Time `json:"purgeAt,omitempty" xml:"purgeAt,omitempty"`
+}
+
+type deletedReceiptsResponse struct {
+	Receipts []deletedReceipt `json:"receipts" xml:"receipt"`
+}
@@ -0,0 +1,95 @@
+// controller/docsController.go
+package controller
+
+import (
+	"html/template"
+	"net/http"
+	"receipt-processor-challenge/openapi"
+)
+
+// APISpec is the API contract served at /openapi.yml and /docs; main loads it
+// from the embedded api.yml.
+var APISpec *openapi.Spec
+
+// GetOpenAPISpec serves the spec exactly as written (GET /openapi.yml)
+func GetOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	if APISpec == nil {
+		writeError(w, r, http.StatusNotFound, CodeEndpointNotFound, "no API spec is loaded")
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(APISpec.Raw)
+}
+
+// GetAPIDocs renders the spec as a static HTML page (GET /docs)
+func GetAPIDocs(w http.ResponseWriter, r *http.Request) {
+	if APISpec == nil {
+		writeError(w, r, http.StatusNotFound, CodeEndpointNotFound, "no API spec is loaded")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	docsTemplate.Execute(w, APISpec)
+}
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Version}}</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: 2em auto; padding: 0 1em; color: #222; }
+h2 { border-bottom: 1px solid #ccc; padding-bottom: .2em; }
+.operation { border: 1px solid #ddd; border-radius: 4px; margin: 1em 0; padding: .5em 1em; }
+.method { display: inline-block; min-width: 4em; font-weight: bold; color: #fff; background: #357; border-radius: 3px; padding: .1em .4em; text-align: center; }
+table { border-collapse: collapse; width: 100%; margin: .5em 0; }
+th, td { border: 1px solid #ddd; padding: .3em .5em; text-align: left; vertical-align: top; }
+code { background: #f4f4f4; padding: 0 .2em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}} <small>{{.Version}}</small></h1>
+<p>{{.Description}}</p>
+<p>Raw spec: <a href="/openapi.yml">/openapi.yml</a></p>
+
+<h2>Endpoints</h2>
+{{range .Operations}}
+<div class="operation" id="{{.Method}} {{.Path}}">
+<h3><span class="method">{{.Method}}</span> <code>{{.Path}}</code></h3>
+<p>{{.Summary}}</p>
+{{if ne .Description .Summary}}<p>{{.Description}}</p>{{end}}
+{{with .Parameters}}
+<h4>Parameters</h4>
+<table>
+<tr><th>Name</th><th>In</th><th>Required</th><th>Schema</th><th>Description</th></tr>
+{{range .}}<tr><td><code>{{.Name}}</code></td><td>{{.In}}</td><td>{{.Required}}</td><td>{{template "schema" .Schema}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>
+{{end}}
+{{with .RequestBody}}
+<h4>Request body{{if .Required}} (required){{end}}</h4>
+{{range $mediaType, $schema := .Content}}<p><code>{{$mediaType}}</code>: {{template "schema" $schema}}</p>{{end}}
+{{end}}
+<h4>Responses</h4>
+<table>
+<tr><th>Status</th><th>Description</th><th>Body</th></tr>
+{{range .Responses}}<tr><td>{{.Status}}</td><td>{{.Description}}</td><td>{{range $mediaType, $schema := .Content}}<code>{{$mediaType}}</code>: {{template "schema" $schema}}<br>{{end}}</td></tr>
+{{end}}</table>
+</div>
+{{end}}
+
+<h2>Schemas</h2>
+{{range .Schemas}}
+<h3 id="schema-{{.Name}}">{{.Name}}</h3>
+{{template "properties" .}}
+{{end}}
+</body>
+</html>
+
+{{define "schema"}}{{if not .}}any{{else if .Name}}<a href="#schema-{{.Name}}">{{.Name}}</a>{{else if eq .Type "array"}}array of {{template "schema" .Items}}{{else if .Properties}}object{{template "properties" .}}{{else}}{{.Type}}{{with .Format}} ({{.}}){{end}}{{with .Pattern}} matching <code>{{.}}</code>{{end}}{{end}}{{end}}
+
+{{define "properties"}}{{$schema := .}}
+<table>
+<tr><th>Property</th><th>Required</th><th>Schema</th><th>Description</th><th>Example</th></tr>
+{{range .PropertyNames}}{{$property := index $schema.Properties .}}<tr><td><code>{{.}}</code></td><td>{{$schema.IsRequired .}}</td><td>{{template "schema" $property}}{{with $property.MinItems}}, at least {{.}}{{end}}</td><td>{{$property.Description}}</td><td>{{with $property.Example}}<code>{{.}}</code>{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+`))
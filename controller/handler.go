@@ -0,0 +1,31 @@
+// controller/handler.go
+package controller
+
+import (
+	"hash/fnv"
+	"receipt-processor-challenge/model"
+	"sync"
+)
+
+// Handler serves the receipt endpoints from one ReceiptStore; two Handlers
+// with different stores are fully isolated.
+type Handler struct {
+	Store model.ReceiptStore
+	// dedupe makes the duplicate check and the Put one step; receipts can only
+	// match within a content key, so it is striped by one
+	dedupe [64]sync.Mutex
+	// edits makes an edit's revision check and its Put one step
+	edits       sync.Mutex
+	idempotency idempotencyCache
+}
+
+func NewHandler(store model.ReceiptStore) *Handler {
+	return &Handler{Store: store}
+}
+
+// dedupeLock is the stripe of h.dedupe for receipts with this content key
+func (h *Handler) dedupeLock(receipt model.Receipt) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(receipt.ContentKey()))
+	return &h.dedupe[hash.Sum32()%uint32(len(h.dedupe))]
+}
@@ -0,0 +1,349 @@
+// controller/idempotency.go
+package controller
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyWindow is how long a response is kept for replay; main.go
+// overrides it from flags.
+var IdempotencyWindow = 24 * time.Hour
+
+// MaxIdempotentBodySize bounds how much of a request body its handler left
+// unread is read afterwards to finish the fingerprint repeats are compared by;
+// the response to a request with more left over is not kept.
+var MaxIdempotentBodySize int64 = 32 << 20
+
+// MaxIdempotentResponseSize bounds the copy kept of one response; a longer
+// response, such as a large stream or import, is passed through but not kept,
+// so its repeat runs again. main.go overrides it from flags.
+var MaxIdempotentResponseSize int64 = 1 << 20
+
+// MaxIdempotencyKeys and MaxIdempotencyBytes bound the kept responses; past
+// either, the oldest are forgotten before their window ends (0 no limit).
+// main.go overrides them from flags.
+var (
+	MaxIdempotencyKeys        = 100000
+	MaxIdempotencyBytes int64 = 64 << 20
+)
+
+const (
+	IdempotencyEvictedMaxKeys  = "max_keys"
+	IdempotencyEvictedMaxBytes = "max_bytes"
+)
+
+// idempotencyCache remembers the first response to each Idempotency-Key. The
+// zero value is ready to use.
+type idempotencyCache struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotentResponse
+	lastSweep time.Time
+	// order holds the kept responses, newest at the front
+	order   list.List
+	bytes   int64
+	evicted map[string]uint64
+}
+
+// idempotencyStats are the cache's metrics.
+type idempotencyStats struct {
+	Keys    int
+	Bytes   int64
+	Evicted map[string]uint64
+}
+
+// idempotentResponse is in flight until done is closed
+type idempotentResponse struct {
+	key         string
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+	expires     time.Time
+	// element is the entry in the cache's order once its response is kept
+	element *list.Element
+	size    int64
+	// set once done; a nil header means the response was not kept (a 5xx or
+	// longer than MaxIdempotentResponseSize)
+	status int
+	header http.Header
+	body   []byte
+}
+
+// withIdempotency replays the stored response for a POST whose
+// Idempotency-Key was seen before with the same method, path and body. The
+// same key with a different request is a 422; a repeat that arrives while the
+// first is still running waits for it. Server errors are not kept, so a retry
+// after one runs again.
+//
+// Request bodies are not buffered: the first request's body is hashed as its
+// handler streams it, and a repeat's only once the first is done. Responses
+// are copied only up to MaxIdempotentResponseSize.
+func (h *Handler) withIdempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, IdempotencyKeyHeader+" must be at most 255 characters")
+			return
+		}
+
+		for {
+			entry, first := h.idempotency.claim(key)
+			if first {
+				h.idempotency.record(key, entry, w, r, next)
+				return
+			}
+
+			select {
+			case <-entry.done:
+			case <-r.Context().Done():
+				return
+			}
+			if entry.header == nil {
+				// the first attempt failed and was forgotten; this one runs instead
+				continue
+			}
+			digest := newFingerprint(r)
+			if _, err := io.Copy(digest, r.Body); err != nil {
+				writeError(w, r, http.StatusBadRequest, CodeMalformedJSON, "error reading request body")
+				return
+			}
+			if fingerprintOf(digest) != entry.fingerprint {
+				writeError(w, r, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused,
+					fmt.Sprintf("%s %q was already used for a different request", IdempotencyKeyHeader, key))
+				return
+			}
+			replay(w, entry)
+			return
+		}
+	})
+}
+
+// claim returns the live entry for key, or a new in-flight one (first=true)
+func (c *idempotencyCache) claim(key string) (*idempotentResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[string]*idempotentResponse)
+	}
+	if now.Sub(c.lastSweep) > IdempotencyWindow/10 {
+		c.sweep(now)
+	}
+
+	if entry, exists := c.entries[key]; exists {
+		if now.Before(entry.expires) || !isDone(entry) {
+			return entry, false
+		}
+		c.forget(entry)
+	}
+	entry := &idempotentResponse{key: key, done: make(chan struct{})}
+	c.entries[key] = entry
+	c.evictLocked()
+	return entry, true
+}
+
+// record runs the handler, passing its response through to the client while
+// keeping a copy and hashing the request body as the handler reads it, and
+// then wakes anyone waiting on the key.
+func (c *idempotencyCache) record(key string, entry *idempotentResponse, w http.ResponseWriter, r *http.Request, next http.Handler) {
+	// full duplex keeps net/http from discarding a body the handler left
+	// unread before it is hashed
+	http.NewResponseController(w).EnableFullDuplex()
+	digest := newFingerprint(r)
+	body := r.Body
+	r.Body = hashingBody{Reader: io.TeeReader(body, digest), Closer: body}
+
+	recorder := &teeRecorder{ResponseWriter: w, limit: MaxIdempotentResponseSize}
+	completed := false
+	defer func() {
+		c.mu.Lock()
+		// a panicking handler is treated like a server error
+		if completed && !recorder.overflow && recorder.status() < http.StatusInternalServerError {
+			entry.status, entry.header, entry.body = recorder.status(), recorder.header, recorder.body.Bytes()
+			if entry.header == nil {
+				// nothing was written
+				entry.header = w.Header().Clone()
+			}
+			entry.expires = time.Now().Add(IdempotencyWindow)
+			if c.entries[key] == entry {
+				entry.size = responseSize(entry)
+				entry.element = c.order.PushFront(entry)
+				c.bytes += entry.size
+				c.evictLocked()
+			}
+		} else if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+		close(entry.done)
+	}()
+	next.ServeHTTP(recorder, r)
+
+	// the rest of the body still counts toward the fingerprint
+	if n, err := io.CopyN(digest, body, MaxIdempotentBodySize+1); err != io.EOF || n > MaxIdempotentBodySize {
+		return
+	}
+	entry.fingerprint = fingerprintOf(digest)
+	completed = true
+}
+
+func (c *idempotencyCache) sweep(now time.Time) {
+	c.lastSweep = now
+	for _, entry := range c.entries {
+		if isDone(entry) && !now.Before(entry.expires) {
+			c.forget(entry)
+		}
+	}
+}
+
+// evictLocked forgets the oldest kept responses until the cache is within
+// its limits. Requests still in flight count toward MaxIdempotencyKeys but
+// cannot be evicted.
+func (c *idempotencyCache) evictLocked() {
+	for c.order.Len() > 0 {
+		reason := ""
+		switch {
+		case MaxIdempotencyKeys > 0 && len(c.entries) > MaxIdempotencyKeys:
+			reason = IdempotencyEvictedMaxKeys
+		case MaxIdempotencyBytes > 0 && c.bytes > MaxIdempotencyBytes:
+			reason = IdempotencyEvictedMaxBytes
+		default:
+			return
+		}
+		c.forget(c.order.Back().Value.(*idempotentResponse))
+		if c.evicted == nil {
+			c.evicted = make(map[string]uint64)
+		}
+		c.evicted[reason]++
+	}
+}
+
+func (c *idempotencyCache) forget(entry *idempotentResponse) {
+	if c.entries[entry.key] == entry {
+		delete(c.entries, entry.key)
+	}
+	if entry.element != nil {
+		c.order.Remove(entry.element)
+		entry.element = nil
+		c.bytes -= entry.size
+	}
+}
+
+func (c *idempotencyCache) stats() idempotencyStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := idempotencyStats{Keys: len(c.entries), Bytes: c.bytes, Evicted: make(map[string]uint64)}
+	for reason, count := range c.evicted {
+		stats.Evicted[reason] = count
+	}
+	return stats
+}
+
+// responseSize estimates the memory a kept response holds
+func responseSize(entry *idempotentResponse) int64 {
+	const entryOverhead = 256
+	size := int64(entryOverhead + len(entry.key) + len(entry.body))
+	for name, values := range entry.header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
+
+func isDone(entry *idempotentResponse) bool {
+	select {
+	case <-entry.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func replay(w http.ResponseWriter, entry *idempotentResponse) {
+	for name, values := range entry.header {
+		if name != RequestIDHeader {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
+
+// newFingerprint starts the hash of what makes two requests "the same":
+// method, path, query, content type and then the body
+func newFingerprint(r *http.Request) hash.Hash {
+	digest := sha256.New()
+	fmt.Fprintf(digest, "%s\n%s\n%s\n", r.Method, r.URL.RequestURI(), r.Header.Get("Content-Type"))
+	return digest
+}
+
+func fingerprintOf(digest hash.Hash) [sha256.Size]byte {
+	var sum [sha256.Size]byte
+	digest.Sum(sum[:0])
+	return sum
+}
+
+// hashingBody is a request body that feeds what is read from it to a hash
+type hashingBody struct {
+	io.Reader
+	io.Closer
+}
+
+// teeRecorder writes through to the client and keeps a copy of the response
+// until it passes limit
+type teeRecorder struct {
+	http.ResponseWriter
+	code     int
+	header   http.Header
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (t *teeRecorder) WriteHeader(status int) {
+	if t.code == 0 {
+		t.code, t.header = status, t.ResponseWriter.Header().Clone()
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *teeRecorder) Write(data []byte) (int, error) {
+	if t.code == 0 {
+		t.WriteHeader(http.StatusOK)
+	}
+	if !t.overflow && int64(t.body.Len()+len(data)) > t.limit {
+		t.overflow = true
+		t.body = bytes.Buffer{}
+	}
+	if !t.overflow {
+		t.body.Write(data)
+	}
+	return t.ResponseWriter.Write(data)
+}
+
+func (t *teeRecorder) status() int {
+	if t.code == 0 {
+		return http.StatusOK
+	}
+	return t.code
+}
+
+// Unwrap lets http.ResponseController reach Flush on the real writer
+func (t *teeRecorder) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
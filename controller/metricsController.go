@@ -0,0 +1,60 @@
+// controller/metricsController.go
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"receipt-processor-challenge/model"
+	"strings"
+)
+
+// statsStore is a store that keeps its own counters, such as model.BoundedStore
+type statsStore interface {
+	Stats() model.StoreStats
+}
+
+// GetMetrics reports store metrics in the Prometheus text format (GET /metrics)
+func (h *Handler) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	// the limits may sit under other wrappers, such as a model.SoftDeleteStore
+	stats, ok := model.UnwrapStore[statsStore](h.Store)
+	if !ok {
+		metric("receipts_stored", "gauge", "Receipts currently stored.")
+		fmt.Fprintf(&b, "receipts_stored %d\n", len(h.Store.List()))
+	} else {
+		s := stats.Stats()
+		metric("receipts_stored", "gauge", "Receipts currently stored, including expired ones not yet swept.")
+		fmt.Fprintf(&b, "receipts_stored %d\n", s.Receipts)
+		metric("receipts_stored_bytes", "gauge", "Estimated memory held by the stored receipts.")
+		fmt.Fprintf(&b, "receipts_stored_bytes %d\n", s.Bytes)
+		metric("receipts_evicted_total", "counter", "Receipts removed to stay within the store limits or because their TTL passed.")
+		for _, reason := range []string{model.EvictedMaxReceipts, model.EvictedMaxBytes, model.EvictedTTL} {
+			fmt.Fprintf(&b, "receipts_evicted_total{reason=%q} %d\n", reason, s.Evicted[reason])
+		}
+	}
+
+	if trash, ok := h.Store.(trashStore); ok {
+		s := trash.TrashStats()
+		metric("receipts_deleted", "gauge", "Deleted receipts that can still be restored.")
+		fmt.Fprintf(&b, "receipts_deleted %d\n", s.Deleted)
+		metric("receipts_purged_total", "counter", "Deleted receipts removed for good after the grace period.")
+		fmt.Fprintf(&b, "receipts_purged_total %d\n", s.Purged)
+	}
+
+	idempotency := h.idempotency.stats()
+	metric("idempotency_keys", "gauge", "Idempotency-Key responses kept for replay, including requests in flight.")
+	fmt.Fprintf(&b, "idempotency_keys %d\n", idempotency.Keys)
+	metric("idempotency_bytes", "gauge", "Estimated memory held by the kept Idempotency-Key responses.")
+	fmt.Fprintf(&b, "idempotency_bytes %d\n", idempotency.Bytes)
+	metric("idempotency_evicted_total", "counter", "Idempotency-Key responses forgotten before their window ended to stay within the limits.")
+	for _, reason := range []string{IdempotencyEvictedMaxKeys, IdempotencyEvictedMaxBytes} {
+		fmt.Fprintf(&b, "idempotency_evicted_total{reason=%q} %d\n", reason, idempotency.Evicted[reason])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
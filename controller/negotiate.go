@@ -0,0 +1,276 @@
+// controller/negotiate.go
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"receipt-processor-challenge/model"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// encoder writes one response body in a negotiated media type. root names the
+// XML document element; JSON ignores it.
+type encoder struct {
+	mediaType string
+	encode    func(w io.Writer, root string, v any) error
+}
+
+// encoders are tried in this order when the client accepts several equally
+var encoders = []encoder{
+	{"application/json", encodeJSON},
+	{"application/xml", encodeXML},
+	{"text/xml", encodeXML},
+	{"text/csv", encodeCSV},
+}
+
+func encodeJSON(w io.Writer, root string, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func encodeXML(w io.Writer, root string, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).EncodeElement(v, xml.StartElement{Name: xml.Name{Local: root}})
+}
+
+func encodeCSV(w io.Writer, root string, v any) error {
+	records, err := csvRecords(v)
+	if err != nil {
+		return err
+	}
+	return csv.NewWriter(w).WriteAll(records)
+}
+
+// csvRecords flattens the response types that have a tabular shape
+func csvRecords(v any) ([][]string, error) {
+	switch v := v.(type) {
+	case model.Receipt:
+		return append([][]string{csvExportHeader}, receiptCSVRows(v)...), nil
+	case model.ReceiptPage:
+		records := [][]string{csvExportHeader}
+		for _, receipt := range v.Receipts {
+			records = append(records, receiptCSVRows(receipt)...)
+		}
+		return records, nil
+	case pointsResponse:
+		return [][]string{{"points"}, {strconv.FormatUint(uint64(v.Points), 10)}}, nil
+	case processResponse:
+		return [][]string{{"id"}, {v.ID}}, nil
+	}
+	return nil, fmt.Errorf("no CSV representation for %T", v)
+}
+
+// acceptedRange is one media range from an Accept header
+type acceptedRange struct {
+	mediaType string
+	q         float64
+}
+
+// negotiate picks the encoder for the request's Accept header. No header (or
+// */*) means JSON. When nothing offered is acceptable it writes a 406 and
+// returns false.
+func negotiate(w http.ResponseWriter, r *http.Request) (encoder, bool) {
+	w.Header().Add("Vary", "Accept")
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return encoders[0], true
+	}
+
+	var ranges []acceptedRange
+	for _, value := range strings.Split(strings.Join(accept, ","), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptedRange{mediaType, q})
+		}
+	}
+	// most preferred first; a more specific range wins a tie
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return strings.Count(ranges[i].mediaType, "*") < strings.Count(ranges[j].mediaType, "*")
+	})
+
+	for _, accepted := range ranges {
+		for _, e := range encoders {
+			if mediaRangeMatches(accepted.mediaType, e.mediaType) {
+				return e, true
+			}
+		}
+	}
+
+	offered := make([]string, len(encoders))
+	for i, e := range encoders {
+		offered[i] = e.mediaType
+	}
+	writeError(w, r, http.StatusNotAcceptable, CodeNotAcceptable,
+		fmt.Sprintf("none of %q can be produced; supported types are %s", strings.Join(accept, ", "), strings.Join(offered, ", ")))
+	return encoder{}, false
+}
+
+func mediaRangeMatches(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(mediaRange, "/*")
+	return ok && strings.HasPrefix(mediaType, prefix+"/")
+}
+
+// writeNegotiated encodes v with the encoder negotiate picked
+func writeNegotiated(w http.ResponseWriter, r *http.Request, e encoder, root string, v any) {
+	if e.mediaType == "text/csv" {
+		if _, err := csvRecords(v); err != nil {
+			writeError(w, r, http.StatusNotAcceptable, CodeNotAcceptable, err.Error())
+			return
+		}
+	}
+	contentType := e.mediaType
+	if contentType != "application/json" {
+		contentType += "; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	e.encode(w, root, v)
+}
+
+// isXMLRequest reports whether a POST body is XML rather than JSON
+func isXMLRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/xml" || mediaType == "text/xml"
+}
+
+// unknownElementError is an XML element the decoded type has no field for;
+// Pointer is where it would be in the JSON body
+type unknownElementError struct {
+	Pointer string
+	Name    string
+}
+
+func (e *unknownElementError) Error() string {
+	return fmt.Sprintf("xml: unknown element <%s> at %s", e.Name, e.Pointer)
+}
+
+// rootElementError is a document whose element is not the one expected
+type rootElementError struct {
+	Expected, Got string
+}
+
+func (e *rootElementError) Error() string {
+	return fmt.Sprintf("xml: expected a <%s> document, got <%s>", e.Expected, e.Got)
+}
+
+// decodeStrictXML decodes a <root> document into v, refusing elements v has no
+// field for as encoding/json's DisallowUnknownFields does. The body is read
+// into memory so it can be checked before it is decoded.
+func decodeStrictXML(body io.Reader, root string, v any) error {
+	data, err := io.ReadAll(limitBody(body))
+	if err != nil {
+		return err
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			if start.Name.Local != root {
+				return &rootElementError{Expected: root, Got: start.Name.Local}
+			}
+			break
+		}
+	}
+	if err := checkXMLElements(decoder, reflect.TypeOf(v).Elem(), nil, ""); err != nil {
+		return err
+	}
+	return xml.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// checkXMLElements reads the rest of an element of type t, checking each child
+// has a field. path is what is left of an a>b field tag; slices number their
+// elements in the pointer, like JSON arrays.
+func checkXMLElements(decoder *xml.Decoder, t reflect.Type, path []string, pointer string) error {
+	counts := map[string]int{}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token := token.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			name := token.Name.Local
+			childType, childPath, ok := xmlChild(t, path, name)
+			if !ok {
+				return &unknownElementError{Pointer: pointer + "/" + name, Name: name}
+			}
+			childPointer := pointer
+			if len(path) == 0 {
+				// the inner steps of an a>b path are not in the JSON shape
+				childPointer += "/" + name
+			}
+			if len(childPath) == 0 && childType.Kind() == reflect.Slice {
+				childPointer += "/" + strconv.Itoa(counts[name])
+				counts[name]++
+				childType = childType.Elem()
+			}
+			if err := checkXMLElements(decoder, childType, childPath, childPointer); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// xmlChild finds the field of t (or the next step of an a>b path) that holds
+// the child element name
+func xmlChild(t reflect.Type, path []string, name string) (reflect.Type, []string, bool) {
+	if len(path) > 0 {
+		return t, path[1:], path[0] == name
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, nil, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, options, _ := strings.Cut(field.Tag.Get("xml"), ",")
+		if !field.IsExported() || field.Name == "XMLName" || tag == "-" || options != "" && !strings.HasPrefix(options, "omitempty") {
+			// attributes, chardata and the like are not elements
+			continue
+		}
+		if field.Anonymous && tag == "" {
+			if childType, childPath, ok := xmlChild(field.Type, nil, name); ok {
+				return childType, childPath, true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		if steps := strings.Split(tag, ">"); steps[0] == name {
+			return field.Type, steps[1:], true
+		}
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,223 @@
+// controller/problem.go
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"receipt-processor-challenge/model"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// problem is the one error body every handler returns: an RFC 7807
+// application/problem+json document with a stable code and the request ID.
+type problem struct {
+	Type      string                  `json:"type"`
+	Title     string                  `json:"title"`
+	Status    int                     `json:"status"`
+	Code      string                  `json:"code"`
+	Detail    string                  `json:"detail"`
+	RequestID string                  `json:"requestId"`
+	Errors    []model.ValidationError `json:"errors,omitempty"`
+	// OriginalID is the stored receipt a duplicate_receipt matches
+	OriginalID string `json:"originalId,omitempty"`
+}
+
+// Stable error codes, safe for clients to switch on
+const (
+	CodeEndpointNotFound     = "endpoint_not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeReceiptNotFound      = "receipt_not_found"
+	CodeInvalidReceipt       = "invalid_receipt"
+	CodeMalformedJSON        = "malformed_json"
+	CodeEmptyBody            = "empty_body"
+	CodeUnknownField         = "unknown_field"
+	CodeInvalidFieldType     = "invalid_field_type"
+	CodeInvalidParameter     = "invalid_parameter"
+	CodeBatchTooLarge        = "batch_too_large"
+	CodeBatchRolledBack      = "batch_rolled_back"
+	CodeLineTooLong          = "line_too_long"
+	CodeInvalidCSV           = "invalid_csv"
+	CodeUnknownReceiptRef    = "unknown_receipt_ref"
+	CodeNotAcceptable        = "not_acceptable"
+	CodeMalformedXML         = "malformed_xml"
+	CodeDuplicateReceipt     = "duplicate_receipt"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeRequestTooLarge      = "request_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodePreconditionRequired = "precondition_required"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeInternalError        = "internal_error"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// requestIDPattern is the X-Request-ID a caller may choose; anything else is
+// replaced rather than echoed back
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// WithRequestID tags every request with an ID (the caller's X-Request-ID if sent
+// and well-formed) and echoes it back, so error bodies can be matched to server logs.
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := callerRequestID(r)
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	// handler called without the middleware (e.g. directly from tests)
+	id := callerRequestID(r)
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
+
+func callerRequestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); requestIDPattern.MatchString(id) {
+		return id
+	}
+	return uuid.New().String()
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	p.RequestID = requestID(w, r)
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeProblem(w, r, problem{Status: status, Code: code, Detail: detail})
+}
+
+// ingestProblem reports every validation error at once, each with its JSON pointer
+func ingestProblem(err error) problem {
+	p := problem{
+		Title:  "The receipt is invalid.",
+		Status: http.StatusBadRequest,
+		Code:   CodeInvalidReceipt,
+		Detail: err.Error(),
+	}
+	var validationErrs model.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		p.Detail = "see errors for every field that failed validation"
+		p.Errors = validationErrs
+	}
+	return p
+}
+
+func writeDecodeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, decodeProblem(err))
+}
+
+// decodeProblem turns encoding/json (and encoding/xml) errors into field-level messages
+// instead of leaking Go wording like "json: unknown field".
+func decodeProblem(err error) problem {
+	p := problem{Status: http.StatusBadRequest, Code: CodeMalformedJSON, Detail: "request body is not valid JSON"}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var xmlSyntaxErr *xml.SyntaxError
+	var tooLarge *http.MaxBytesError
+	var rootErr *rootElementError
+	var elementErr *unknownElementError
+	switch {
+	case errors.Is(err, io.EOF):
+		p.Code, p.Detail = CodeEmptyBody, "request body cannot be empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		p.Detail = "request body ended unexpectedly"
+	case errors.As(err, &syntaxErr):
+		p.Detail = fmt.Sprintf("request body is not valid JSON (error at byte %d)", syntaxErr.Offset)
+	case errors.As(err, &xmlSyntaxErr):
+		p.Code, p.Detail = CodeMalformedXML, fmt.Sprintf("request body is not valid XML (error on line %d)", xmlSyntaxErr.Line)
+	case errors.As(err, &rootErr):
+		p.Code, p.Detail = CodeMalformedXML, fmt.Sprintf("the document element must be <%s>, not <%s>", rootErr.Expected, rootErr.Got)
+	case errors.As(err, &elementErr):
+		p.Code, p.Detail = CodeUnknownField, "the receipt has an element that is not part of the schema"
+		p.Errors = []model.ValidationError{{
+			Pointer: elementErr.Pointer,
+			Code:    CodeUnknownField,
+			Message: fmt.Sprintf("unknown element <%s>", elementErr.Name),
+		}}
+	case errors.As(err, &tooLarge):
+		p.Status, p.Code = http.StatusRequestEntityTooLarge, CodeRequestTooLarge
+		p.Detail = fmt.Sprintf("request body can be at most %d bytes", tooLarge.Limit)
+	case errors.As(err, &typeErr):
+		// Field is the dotted path, array indexes included (items.1.price)
+		pointer, name := "", typeErr.Field
+		if name != "" {
+			pointer = "/" + strings.ReplaceAll(name, ".", "/")
+		} else {
+			name = "the request body"
+		}
+		p.Code, p.Detail = CodeInvalidFieldType, "a field has the wrong type"
+		p.Errors = []model.ValidationError{{
+			Pointer: pointer,
+			Code:    CodeInvalidFieldType,
+			Message: fmt.Sprintf("%s must be a %s, not a %s", name, jsonTypeName(typeErr.Type.Kind().String()), typeErr.Value),
+		}}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		// encoding/json names the field but not where it is
+		pointer := "/" + field
+		p.Code, p.Detail = CodeUnknownField, "the receipt has a field that is not part of the schema"
+		p.Errors = []model.ValidationError{{
+			Pointer: pointer,
+			Code:    CodeUnknownField,
+			Message: fmt.Sprintf("unknown field %q", field),
+		}}
+	}
+	return p
+}
+
+// MaxReceiptSize caps the body of one receipt (process, replace, patch); a
+// longer body is a 413.
+var MaxReceiptSize int64 = 1 << 20
+
+// limitBody stops reading a receipt body past MaxReceiptSize with an
+// *http.MaxBytesError
+func limitBody(body io.Reader) io.Reader {
+	return http.MaxBytesReader(nil, io.NopCloser(body), MaxReceiptSize)
+}
+
+// decodeStrictJSON decodes one JSON value into v, rejecting fields v has no
+// field for.
+func decodeStrictJSON(body io.Reader, v any) error {
+	decoder := json.NewDecoder(limitBody(body))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+func jsonTypeName(kind string) string {
+	switch kind {
+	case "slice", "array":
+		return "array"
+	case "struct", "map":
+		return "object"
+	case "uint", "uint64", "int", "int64", "float64":
+		return "number"
+	}
+	return kind
+}
@@ -2,137 +2,306 @@
 package controller
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"receipt-processor-challenge/config"
 	"receipt-processor-challenge/model"
-	"regexp"
-	"strings"
+	"strconv"
 )
 
 // GET MethodS
-func GetReceipt(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/receipts/"), "/")
-	//path := strings.TrimPrefix(r.URL.Path, "/receipts/")
-	if path == "" {
-		// Return all receipts
-		receipts := model.GetAllReceipts()
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(receipts)
+// ListReceipts returns a page of stored receipts (GET /receipts/)
+// filtered/sorted by the query string; follow "next" for the following page.
+func (h *Handler) ListReceipts(w http.ResponseWriter, r *http.Request) {
+	e, ok := negotiate(w, r)
+	if !ok {
 		return
 	}
-	// Check if the path ends with "/points" to route to the GetReceiptPoints handler
-	if strings.HasSuffix(path, "/points") {
-		id := strings.TrimSuffix(path, "/points")
-		GetReceiptPoints(w, r, id)
+	query, err := parseReceiptQuery(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	page, err := model.QueryReceipts(h.Store, query)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
 		return
 	}
-	// Return specific receipt
-	receipt, exists := model.GetReceiptById(path)
+	for i := range page.Receipts {
+		if err := applyReceiptView(r, &page.Receipts[i]); err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+			return
+		}
+	}
+	writeNegotiated(w, r, e, "receipts", page)
+}
+
+// GetReceipt returns a single receipt (GET /receipts/{id})
+func (h *Handler) GetReceipt(w http.ResponseWriter, r *http.Request) {
+	e, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	receipt, exists := h.Store.Get(r.PathValue("id"))
 	if !exists {
-		http.Error(w, "Receipt not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, CodeReceiptNotFound, "Receipt not found")
+		return
+	}
+	w.Header().Set("ETag", receipt.ETag())
+	if err := applyReceiptView(r, &receipt); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(receipt)
+	writeNegotiated(w, r, e, "receipt", receipt)
 }
 
 // POST Method
-func ProcessReceipt(w http.ResponseWriter, r *http.Request) {
-	var receipt model.Receipt
-	receipt.Items = []model.Item{} // Initialize Items to an empty slice
-
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields() // Reject unknown 
-	err := decoder.Decode(&receipt)
+// ProcessReceipt accepts a JSON body, or XML with an XML Content-Type.
+func (h *Handler) ProcessReceipt(w http.ResponseWriter, r *http.Request) {
+	e, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	profile, err := validationProfile(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
 		return
 	}
 
-	// Validate receipt before any processing
-    if err := receipt.ValidateReceipt(); err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return
-    }
-
-	// run trimming operation for itemShortDescriptions...
-	cleanItemShortDescriptions(&receipt)
-
-    // Format date using consolidated utility
-    formattedDate, err := config.ValidateAndFormatDate(receipt.PurchaseDate)
-    if err != nil {
-        http.Error(w, fmt.Sprintf("Date formatting error: %v", err), http.StatusBadRequest)
-        return
-    }
-    receipt.PurchaseDate = formattedDate
-
-    // Format time using consolidated utility
-    formattedTime, err := config.ValidateAndFormatTime(receipt.PurchaseTime)
-    if err != nil {
-        http.Error(w, fmt.Sprintf("Time formatting error: %v", err), http.StatusBadRequest)
-        return
-    }
-    receipt.PurchaseTime = formattedTime
+	decode := decodeReceipt
+	if isXMLRequest(r) {
+		decode = decodeReceiptXML
+	}
+	receipt, err := decode(r.Body)
+	if err != nil {
+		writeDecodeProblem(w, r, err)
+		return
+	}
 
-	receipt.GenerateUniqueID()
-	receipt.CalculatePoints()
+	if err := ingestReceipt(&receipt, profile); err != nil {
+		writeProblem(w, r, ingestProblem(err))
+		return
+	}
 
-	model.AddReceipt(receipt)
-	
+	if err := h.putReceipt(&receipt); err != nil {
+		writeProblem(w, r, storeProblem(err))
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(struct {
-		ID string `json:"id"`
-	}{
-		ID: receipt.ID,
+	writeNegotiated(w, r, e, "receipt", processResponse{
+		ID:       receipt.ID,
+		Warnings: receipt.Warnings,
 	})
 }
 
-// Updated handler to get points for a specific receipt
-func GetReceiptPoints(w http.ResponseWriter, r *http.Request, id string) {
-	receipt, exists := model.GetReceiptById(id)
+type processResponse struct {
+	ID       string          `json:"id" xml:"id"`
+	Warnings []model.Warning `json:"warnings,omitempty" xml:"warnings>warning,omitempty"`
+}
+
+// GetReceiptPoints returns the points for a receipt (GET /receipts/{id}/points)
+func (h *Handler) GetReceiptPoints(w http.ResponseWriter, r *http.Request) {
+	e, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	receipt, exists := h.Store.Get(r.PathValue("id"))
 	if !exists {
-		http.Error(w, "Receipt not found, as such, 0 Points", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, CodeReceiptNotFound, "Receipt not found, as such, 0 Points")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(struct {
-		Points uint `json:"points"`
-	}{
-		Points: receipt.Points,
-	})
+	writeNegotiated(w, r, e, "points", pointsResponse{Points: receipt.Points})
+}
+
+type pointsResponse struct {
+	Points uint `json:"points" xml:",chardata"`
 }
 
 // NotFoundHandler handles requests to non-existent endpoints
 func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
-    // Set the status code to 404
-    w.WriteHeader(http.StatusNotFound)
-    
-    // Write a standard error message
-    response := map[string]string{
-        "error": "Endpoint not found",
-        "message": fmt.Sprintf("The requested URL %s was not found on this server.", r.URL.Path),
-    }
-    
-    // Write the response in JSON format
-    if err := json.NewEncoder(w).Encode(response); err != nil {
-        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-    }
+    writeProblem(w, r, problem{
+        Title:  "Endpoint not found",
+        Status: http.StatusNotFound,
+        Code:   CodeEndpointNotFound,
+        Detail: fmt.Sprintf("The requested URL %s was not found on this server.", r.URL.Path),
+    })
 }
 /*
 	Helper Functions
 */
-// Clean item descriptions by trimming and reducing multiple spaces
-func cleanItemShortDescriptions(receipt *model.Receipt) {
-	for i, item := range receipt.Items {
-		// Trim leading and trailing spaces
-		description := strings.TrimSpace(item.ShortDescription)
-		// Replace multiple spaces with a single space
-		re := regexp.MustCompile(`\s+`)
-		description = re.ReplaceAllString(description, " ")
-		receipt.Items[i].ShortDescription = description
-	}
-}
\ No newline at end of file
+// decodeReceipt reads one JSON receipt, rejecting fields that are not in the schema
+func decodeReceipt(body io.Reader) (model.Receipt, error) {
+	var receipt model.Receipt
+	receipt.Items = []model.Item{} // Initialize Items to an empty slice
+
+	err := decodeStrictJSON(body, &receipt) // Reject unknown fields
+	return receipt, err
+}
+
+// decodeReceiptXML reads one <receipt> document with the same fields as the JSON body
+func decodeReceiptXML(body io.Reader) (model.Receipt, error) {
+	var receipt model.Receipt
+	receipt.Items = []model.Item{}
+
+	err := decodeStrictXML(body, "receipt", &receipt)
+	return receipt, err
+}
+
+// ingestReceipt runs a decoded receipt through the same steps every submission
+// path uses: keep the raw input, validate, normalize, assign an ID and score.
+// The receipt is not stored.
+func ingestReceipt(receipt *model.Receipt, profile model.ValidationProfile) error {
+	if err := scoreReceipt(receipt, profile); err != nil {
+		return err
+	}
+	receipt.GenerateUniqueID()
+	return nil
+}
+
+// scoreReceipt is ingestReceipt without the new ID, for edits to a stored receipt
+func scoreReceipt(receipt *model.Receipt, profile model.ValidationProfile) error {
+	// keep what was submitted before anything is normalized
+	receipt.CaptureRaw()
+
+	// Validate receipt before any processing
+	if err := receipt.ValidateReceiptWithProfile(profile); err != nil {
+		return err
+	}
+
+	// purchasedAt split, description trimming, date + time formatting
+	if err := receipt.Normalize(); err != nil {
+		return err
+	}
+
+	receipt.CalculatePoints()
+	return nil
+}
+
+// duplicateError is a receipt the duplicate policy refused
+type duplicateError struct {
+	duplicate model.Duplicate
+}
+
+func (e *duplicateError) Error() string {
+	return "receipt matches stored receipt " + e.duplicate.Original.ID
+}
+
+// putReceipt applies model.ActiveDuplicatePolicy, then stores the receipt:
+// a duplicate is refused with a *duplicateError or flagged with a warning.
+func (h *Handler) putReceipt(receipt *model.Receipt) error {
+	policy := model.ActiveDuplicatePolicy
+	if policy == model.DuplicateAccept {
+		return h.Store.Put(*receipt)
+	}
+
+	lock := h.dedupeLock(*receipt)
+	lock.Lock()
+	defer lock.Unlock()
+	if duplicate, found := model.FindDuplicate(h.Store, *receipt); found {
+		if policy == model.DuplicateReject {
+			return &duplicateError{duplicate}
+		}
+		receipt.Warnings = append(receipt.Warnings, duplicate.Warning())
+	}
+	return h.Store.Put(*receipt)
+}
+
+// storeProblem reports a putReceipt error: a 409 naming the original for a
+// refused duplicate, otherwise a 500 that does not leak the store's error
+func storeProblem(err error) problem {
+	var duplicate *duplicateError
+	if errors.As(err, &duplicate) {
+		kind := "a near-duplicate"
+		if duplicate.duplicate.Exact {
+			kind = "an exact duplicate"
+		}
+		return problem{
+			Title:      "The receipt was already submitted.",
+			Status:     http.StatusConflict,
+			Code:       CodeDuplicateReceipt,
+			Detail:     fmt.Sprintf("this receipt is %s of receipt %s", kind, duplicate.duplicate.Original.ID),
+			OriginalID: duplicate.duplicate.Original.ID,
+		}
+	}
+	return problem{Status: http.StatusInternalServerError, Code: CodeInternalError, Detail: "the receipt could not be stored"}
+}
+
+// applyReceiptView hides the raw submitted input unless ?view=raw is requested
+func applyReceiptView(r *http.Request, receipt *model.Receipt) error {
+	switch view := r.URL.Query().Get("view"); view {
+	case "", "normalized":
+		receipt.Raw = nil
+	case "raw":
+	default:
+		return fmt.Errorf("unknown view %q (expected normalized or raw)", view)
+	}
+	return nil
+}
+
+// validationProfile picks the per-request profile (?validation= or the
+// X-Validation-Profile header), falling back to the deployment default
+func validationProfile(r *http.Request) (model.ValidationProfile, error) {
+	profile := r.URL.Query().Get("validation")
+	if profile == "" {
+		profile = r.Header.Get("X-Validation-Profile")
+	}
+	if profile == "" {
+		return model.DefaultValidationProfile, nil
+	}
+	return model.ParseValidationProfile(profile)
+}
+
+// parseReceiptQuery reads the list filters:
+// retailer, from, to, minTotal, maxTotal, minPoints, maxPoints, sort, limit, cursor
+func parseReceiptQuery(r *http.Request) (model.ReceiptQuery, error) {
+	params := r.URL.Query()
+	query := model.ReceiptQuery{
+		Retailer: params.Get("retailer"),
+		Sort:     params.Get("sort"),
+		Cursor:   params.Get("cursor"),
+	}
+
+	// dates accept any supported format and are compared as YYYY-MM-DD
+	for name, target := range map[string]*string{"from": &query.From, "to": &query.To} {
+		if value := params.Get(name); value != "" {
+			date, err := config.ValidateAndFormatDate(value)
+			if err != nil {
+				return query, fmt.Errorf("%s: %v", name, err)
+			}
+			*target = date
+		}
+	}
+
+	for name, target := range map[string]**float64{"minTotal": &query.MinTotal, "maxTotal": &query.MaxTotal} {
+		if value := params.Get(name); value != "" {
+			total, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return query, fmt.Errorf("%s must be a number", name)
+			}
+			*target = &total
+		}
+	}
+
+	for name, target := range map[string]**uint{"minPoints": &query.MinPoints, "maxPoints": &query.MaxPoints} {
+		if value := params.Get(name); value != "" {
+			points, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return query, fmt.Errorf("%s must be a non-negative integer", name)
+			}
+			p := uint(points)
+			*target = &p
+		}
+	}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > model.MaxQueryLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", model.MaxQueryLimit)
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
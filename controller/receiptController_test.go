@@ -1,226 +1,1883 @@
 package controller
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"receipt-processor-challenge/model"
+	"receipt-processor-challenge/openapi"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 // Test Helpers
 // Enhanced test helper with more complete data
 func createTestReceipt() model.Receipt {
-    return model.Receipt{
-        Retailer: "Target",
-        PurchaseDate: "2024-02-07",
-        PurchaseTime: "13:45",
-        Items: []model.Item{
-            {
-                ShortDescription: "Mountain Dew",
-                Price: "1.99",
-            },
-        },
-        Total: "1.99", // Make sure total matches items
-    }
+	return model.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2024-02-07",
+		PurchaseTime: "13:45",
+		Items: []model.Item{
+			{
+				ShortDescription: "Mountain Dew",
+				Price:            "1.99",
+			},
+		},
+		Total: "1.99", // Make sure total matches items
+	}
 }
 
 // Unit Tests
 func TestProcessReceipt(t *testing.T) {
-    testData := GetReceiptTestData() // Only get receipt test data
-
-    for _, tc := range testData.Valid {
-        t.Run(tc.Name, func(t *testing.T) {
-            req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(tc.Input))
-            req.Header.Set("Content-Type", "application/json")
-            rr := httptest.NewRecorder()
-
-            ProcessReceipt(rr, req)
-
-            if rr.Code != tc.StatusCode {
-                t.Errorf("Expected status code %d, got %d", tc.StatusCode, rr.Code)
-            }
-        })
-    }
-
-    for _, tc := range testData.Invalid {
-        t.Run(tc.Name, func(t *testing.T) {
-            req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(tc.Input))
-            req.Header.Set("Content-Type", "application/json")
-            rr := httptest.NewRecorder()
-
-            ProcessReceipt(rr, req)
-
-            if rr.Code != tc.StatusCode {
-                t.Errorf("Expected status code %d, got %d", tc.StatusCode, rr.Code)
-            }
-        })
-    }
-}
-
- func TestGetReceipt_Comprehensive(t *testing.T) {
-    testData := GetGetterReceiptTestData()
-
-    // Test valid cases
-    for _, tc := range testData.Valid {
-        t.Run(tc.Name, func(t *testing.T) {
-            // Setup
-            model.ClearReceipts()
-            var receiptID string
-            if tc.SetupReceipt {
-                receipt := createTestReceipt()
-                receipt.GenerateUniqueID()
-                receipt.CalculatePoints()
-                model.AddReceipt(receipt)
-                receiptID = receipt.ID
-            }
-
-            // Format path if needed
-            path := tc.Path
-            if strings.Contains(path, "%s") {
-                path = fmt.Sprintf(tc.Path, receiptID)
-            }
-
-            // Create and execute request
-            req := httptest.NewRequest("GET", path, nil)
-            rr := httptest.NewRecorder()
-
-            GetReceipt(rr, req)
-
-            // Check status code
-            if status := rr.Code; status != tc.ExpectedStatus {
-                t.Errorf("handler returned wrong status code: got %v want %v",
-                    status, tc.ExpectedStatus)
-            }
-
-            // Validate response based on the type of request
-            switch {
-            case strings.HasSuffix(path, "/points"):
-                var response struct {
-                    Points uint `json:"points"`
-                }
-                if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
-                    t.Fatalf("Failed to decode points response: %v", err)
-                }
-            case strings.HasSuffix(path, "/"):
-                var receipts []model.Receipt
-                if err := json.NewDecoder(rr.Body).Decode(&receipts); err != nil {
-                    t.Fatalf("Failed to decode receipts list: %v", err)
-                }
-                if tc.SetupReceipt && len(receipts) != 1 {
-                    t.Errorf("Expected 1 receipt, got %d", len(receipts))
-                }
-            default:
-                var receipt model.Receipt
-                if err := json.NewDecoder(rr.Body).Decode(&receipt); err != nil {
-                    t.Fatalf("Failed to decode receipt: %v", err)
-                }
-                if receipt.ID != receiptID {
-                    t.Errorf("Expected receipt ID %s, got %s", receiptID, receipt.ID)
-                }
-            }
-        })
-    }
-
-    // Test invalid cases
-    for _, tc := range testData.Invalid {
-        t.Run(tc.Name, func(t *testing.T) {
-            // Setup
-            model.ClearReceipts()
-            
-            // Create and execute request
-            req := httptest.NewRequest("GET", tc.Path, nil)
-            rr := httptest.NewRecorder()
-
-            GetReceipt(rr, req)
-
-            // Check status code
-            if status := rr.Code; status != tc.ExpectedStatus {
-                t.Errorf("handler returned wrong status code: got %v want %v",
-                    status, tc.ExpectedStatus)
-            }
-
-            // Check error message
-            if !strings.Contains(rr.Body.String(), tc.ExpectedBody) {
-                t.Errorf("Expected response to contain '%s', got '%s'", 
-                    tc.ExpectedBody, rr.Body.String())
-            }
-        })
-    }
+	store := model.NewMemoryStore()
+	testData := GetReceiptTestData() // Only get receipt test data
+
+	for _, tc := range testData.Valid {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(tc.Input))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			NewHandler(store).ProcessReceipt(rr, req)
+
+			if rr.Code != tc.StatusCode {
+				t.Errorf("Expected status code %d, got %d", tc.StatusCode, rr.Code)
+			}
+		})
+	}
+
+	for _, tc := range testData.Invalid {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(tc.Input))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			NewHandler(store).ProcessReceipt(rr, req)
+
+			if rr.Code != tc.StatusCode {
+				t.Errorf("Expected status code %d, got %d", tc.StatusCode, rr.Code)
+			}
+		})
+	}
+}
+
+func TestGetReceipt_Comprehensive(t *testing.T) {
+	testData := GetGetterReceiptTestData()
+
+	// Test valid cases
+	for _, tc := range testData.Valid {
+		t.Run(tc.Name, func(t *testing.T) {
+			// Setup
+			store := model.NewMemoryStore()
+			var receiptID string
+			if tc.SetupReceipt {
+				receipt := createTestReceipt()
+				receipt.GenerateUniqueID()
+				receipt.CalculatePoints()
+				store.Put(receipt)
+				receiptID = receipt.ID
+			}
+
+			// Format path if needed
+			path := tc.Path
+			if strings.Contains(path, "%s") {
+				path = fmt.Sprintf(tc.Path, receiptID)
+			}
+
+			// Create and execute request
+			req := httptest.NewRequest("GET", path, nil)
+			rr := httptest.NewRecorder()
+
+			NewRouter(store).ServeHTTP(rr, req)
+
+			// Check status code
+			if status := rr.Code; status != tc.ExpectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tc.ExpectedStatus)
+			}
+
+			// Validate response based on the type of request
+			switch {
+			case strings.HasSuffix(path, "/points"):
+				var response struct {
+					Points uint `json:"points"`
+				}
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatalf("Failed to decode points response: %v", err)
+				}
+			case strings.HasSuffix(path, "/"):
+				var page model.ReceiptPage
+				if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+					t.Fatalf("Failed to decode receipts list: %v", err)
+				}
+				if tc.SetupReceipt && len(page.Receipts) != 1 {
+					t.Errorf("Expected 1 receipt, got %d", len(page.Receipts))
+				}
+			default:
+				var receipt model.Receipt
+				if err := json.NewDecoder(rr.Body).Decode(&receipt); err != nil {
+					t.Fatalf("Failed to decode receipt: %v", err)
+				}
+				if receipt.ID != receiptID {
+					t.Errorf("Expected receipt ID %s, got %s", receiptID, receipt.ID)
+				}
+			}
+		})
+	}
+
+	// Test invalid cases
+	for _, tc := range testData.Invalid {
+		t.Run(tc.Name, func(t *testing.T) {
+			// Setup
+			store := model.NewMemoryStore()
+
+			// Create and execute request
+			req := httptest.NewRequest("GET", tc.Path, nil)
+			rr := httptest.NewRecorder()
+
+			NewRouter(store).ServeHTTP(rr, req)
+
+			// Check status code
+			if status := rr.Code; status != tc.ExpectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tc.ExpectedStatus)
+			}
+
+			// Check error message
+			if !strings.Contains(rr.Body.String(), tc.ExpectedBody) {
+				t.Errorf("Expected response to contain '%s', got '%s'",
+					tc.ExpectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestListReceiptsQuery(t *testing.T) {
+	store := model.NewMemoryStore()
+	for i, date := range []string{"2024-02-01", "2024-02-02", "2024-02-03", "2024-02-04", "2024-02-05"} {
+		receipt := createTestReceipt()
+		receipt.ID = fmt.Sprintf("receipt-%d", i)
+		receipt.PurchaseDate = date
+		if i%2 == 0 {
+			receipt.Retailer = "Walmart"
+		}
+		receipt.CalculatePoints()
+		store.Put(receipt)
+	}
+
+	list := func(target string) (int, model.ReceiptPage) {
+		rr := httptest.NewRecorder()
+		NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		var page model.ReceiptPage
+		json.NewDecoder(rr.Body).Decode(&page)
+		return rr.Code, page
+	}
+	ids := func(page model.ReceiptPage) []string {
+		var result []string
+		for _, receipt := range page.Receipts {
+			result = append(result, receipt.ID)
+		}
+		return result
+	}
+
+	// walk every page in descending date order
+	var walked []string
+	target := "/receipts/?sort=-purchaseDate&limit=2"
+	for pages := 0; target != ""; pages++ {
+		status, page := list(target)
+		if status != http.StatusOK || pages > 3 {
+			t.Fatalf("Unexpected status %d after %d pages", status, pages)
+		}
+		walked = append(walked, ids(page)...)
+		target = ""
+		if page.Next != "" {
+			target = "/receipts/?sort=-purchaseDate&limit=2&cursor=" + page.Next
+		}
+	}
+	expected := []string{"receipt-4", "receipt-3", "receipt-2", "receipt-1", "receipt-0"}
+	if !reflect.DeepEqual(walked, expected) {
+		t.Errorf("Expected %v, got %v", expected, walked)
+	}
+
+	// filters
+	_, page := list("/receipts/?retailer=walmart&from=02/02/2024&to=2024-02-05")
+	if got := ids(page); !reflect.DeepEqual(got, []string{"receipt-2", "receipt-4"}) {
+		t.Errorf("Expected walmart receipts in range, got %v", got)
+	}
+
+	for _, target := range []string{"/receipts/?sort=name", "/receipts/?limit=0", "/receipts/?minTotal=abc", "/receipts/?cursor=bogus"} {
+		if status, _ := list(target); status != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", target, status)
+		}
+	}
+}
+
+func TestGetReceiptRawView(t *testing.T) {
+	store := model.NewMemoryStore()
+	input := `{
+        "retailer": "Target",
+        "purchaseDate": "Feb 7, 2024",
+        "purchaseTime": "1:45 PM",
+        "items": [{"shortDescription": "  Mountain   Dew ", "price": "1.99"}],
+        "total": "1.99"
+    }`
+	req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(input))
+	rr := httptest.NewRecorder()
+	NewHandler(store).ProcessReceipt(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	// Default view hides the raw input
+	rr = httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+created.ID, nil))
+	var normalized model.Receipt
+	json.NewDecoder(rr.Body).Decode(&normalized)
+	if normalized.Raw != nil {
+		t.Errorf("Expected raw input to be hidden by default, got %+v", normalized.Raw)
+	}
+	if normalized.PurchaseDate != "2024-02-07" || normalized.PurchaseTime != "13:45" {
+		t.Errorf("Expected normalized 2024-02-07 13:45, got %s %s", normalized.PurchaseDate, normalized.PurchaseTime)
+	}
+
+	// ?view=raw exposes the submitted strings + matched formats
+	rr = httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+created.ID+"?view=raw", nil))
+	var raw model.Receipt
+	json.NewDecoder(rr.Body).Decode(&raw)
+	if raw.Raw == nil {
+		t.Fatal("Expected raw input with ?view=raw")
+	}
+	if raw.Raw.PurchaseDate != "Feb 7, 2024" || raw.Raw.PurchaseTime != "1:45 PM" {
+		t.Errorf("Expected raw date/time to be kept, got %s %s", raw.Raw.PurchaseDate, raw.Raw.PurchaseTime)
+	}
+	if raw.Raw.DateFormat != "Month DD, YYYY" || raw.Raw.TimeFormat != "12-hour format without leading zero" {
+		t.Errorf("Unexpected matched formats %q / %q", raw.Raw.DateFormat, raw.Raw.TimeFormat)
+	}
+	if raw.Raw.Items[0].ShortDescription != "  Mountain   Dew " || raw.Raw.Total != "1.99" {
+		t.Errorf("Expected raw items/total to be kept, got %+v", raw.Raw)
+	}
+
+	// Unknown views are rejected
+	rr = httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+created.ID+"?view=bogus", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for unknown view, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestProcessReceiptWarnings(t *testing.T) {
+	store := model.NewMemoryStore()
+	input := `{
+        "retailer": "Target",
+        "purchaseDate": "02/07/2024",
+        "purchaseTime": "13:45:10",
+        "items": [{"shortDescription": "Mountain Dew", "price": "1.99"}],
+        "total": "1.99"
+    }`
+	req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(input))
+	rr := httptest.NewRecorder()
+	NewHandler(store).ProcessReceipt(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		ID       string          `json:"id"`
+		Warnings []model.Warning `json:"warnings"`
+	}
+	json.NewDecoder(rr.Body).Decode(&response)
+	if len(response.Warnings) != 2 {
+		t.Fatalf("Expected 2 warnings in the response, got %+v", response.Warnings)
+	}
+
+	// warnings are persisted with the receipt
+	stored, _ := store.Get(response.ID)
+	if !reflect.DeepEqual(stored.Warnings, response.Warnings) {
+		t.Errorf("Expected stored warnings %+v, got %+v", response.Warnings, stored.Warnings)
+	}
+}
+
+func TestProcessReceiptProblemDetails(t *testing.T) {
+	store := model.NewMemoryStore()
+	input := `{
+        "retailer": "",
+        "purchaseDate": "2024-02-07",
+        "purchaseTime": "25:00",
+        "items": [
+            {"shortDescription": "Mountain Dew", "price": "1.99"},
+            {"shortDescription": "Doritos", "price": "0.00"}
+        ],
+        "total": "1.99"
+    }`
+	req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(input))
+	rr := httptest.NewRecorder()
+	NewHandler(store).ProcessReceipt(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Errorf("Expected application/problem+json, got %s", contentType)
+	}
+
+	var body struct {
+		Status int                     `json:"status"`
+		Errors []model.ValidationError `json:"errors"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode problem body: %v", err)
+	}
+	var pointers []string
+	for _, e := range body.Errors {
+		pointers = append(pointers, e.Pointer)
+	}
+	expected := []string{"/retailer", "/purchaseTime", "/items/1/price"}
+	if body.Status != http.StatusBadRequest || !reflect.DeepEqual(pointers, expected) {
+		t.Errorf("Expected status 400 with errors at %v, got %d %v", expected, body.Status, pointers)
+	}
+}
+
+func TestProcessReceiptDecodeErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		code    string
+		pointer string
+	}{
+		{"Empty body", ``, CodeEmptyBody, ""},
+		{"Syntax error", `{invalid json}`, CodeMalformedJSON, ""},
+		{"Truncated body", `{"retailer": "Target"`, CodeMalformedJSON, ""},
+		{"Unknown field", `{"retailer": "Target", "cashier": "Bob"}`, CodeUnknownField, "/cashier"},
+		{"Wrong type", `{"retailer": "Target", "total": 1.99}`, CodeInvalidFieldType, "/total"},
+		{"Wrong type in an item", `{"retailer": "Target", "items": [{"price": "1.00"}, {"price": 2}]}`, CodeInvalidFieldType, "/items/1/price"},
+		{"Object for a string", `{"retailer": {"name": "Target"}}`, CodeInvalidFieldType, "/retailer"},
+		{"Object for the items", `{"items": {"price": "1.00"}}`, CodeInvalidFieldType, "/items"},
+		// encoding/json does not say where an unknown field is, only its name
+		{"Unknown field in an item", `{"retailer": "Target", "items": [{"price": "1.00"}, {"Price": "2.00", "discount": "1.00"}]}`, CodeUnknownField, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(tc.input))
+			req.Header.Set(RequestIDHeader, "test-request")
+			rr := httptest.NewRecorder()
+			WithRequestID(http.HandlerFunc(NewHandler(model.NewMemoryStore()).ProcessReceipt)).ServeHTTP(rr, req)
+
+			var body problem
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode problem body: %v", err)
+			}
+			if rr.Code != http.StatusBadRequest || body.Code != tc.code {
+				t.Errorf("Expected 400 %s, got %d %s", tc.code, rr.Code, body.Code)
+			}
+			if body.RequestID != "test-request" {
+				t.Errorf("Expected the caller's request ID, got %q", body.RequestID)
+			}
+			if strings.Contains(body.Detail, "json:") {
+				t.Errorf("Expected a friendly message, got %q", body.Detail)
+			}
+			if tc.pointer != "" && (len(body.Errors) != 1 || body.Errors[0].Pointer != tc.pointer) {
+				t.Errorf("Expected a field error at %s, got %+v", tc.pointer, body.Errors)
+			}
+		})
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	testCases := []struct {
+		name   string
+		sent   string
+		echoed bool
+	}{
+		{"Token", "req-42.a_B", true},
+		{"Longest", strings.Repeat("a", 128), true},
+		{"Too long", strings.Repeat("a", 129), false},
+		{"Markup", "<script>alert(1)</script>", false},
+		{"Spaces", "two words", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/receipts/unknown", nil)
+			req.Header.Set(RequestIDHeader, tc.sent)
+			rr := httptest.NewRecorder()
+			NewRouter(model.NewMemoryStore()).ServeHTTP(rr, req)
+
+			var body problem
+			json.NewDecoder(rr.Body).Decode(&body)
+			id := rr.Header().Get(RequestIDHeader)
+			if (id == tc.sent) != tc.echoed || body.RequestID != id {
+				t.Errorf("Sent %q: got header %q and body %q, echoed should be %v", tc.sent, id, body.RequestID, tc.echoed)
+			}
+			if _, err := uuid.Parse(id); !tc.echoed && err != nil {
+				t.Errorf("Expected a generated UUID, got %q", id)
+			}
+		})
+	}
+}
+
+func TestProcessReceiptTooLarge(t *testing.T) {
+	defer func(saved int64) { MaxReceiptSize = saved }(MaxReceiptSize)
+	MaxReceiptSize = 64
+	for _, contentType := range []string{"application/json", "application/xml"} {
+		t.Run(contentType, func(t *testing.T) {
+			input := `{"retailer": "` + strings.Repeat("a", 100) + `"}`
+			if contentType == "application/xml" {
+				input = "<receipt><retailer>" + strings.Repeat("a", 100) + "</retailer></receipt>"
+			}
+			req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(input))
+			req.Header.Set("Content-Type", contentType)
+			rr := httptest.NewRecorder()
+			NewRouter(model.NewMemoryStore()).ServeHTTP(rr, req)
+
+			var body problem
+			json.NewDecoder(rr.Body).Decode(&body)
+			if rr.Code != http.StatusRequestEntityTooLarge || body.Code != CodeRequestTooLarge {
+				t.Errorf("Expected 413 %s, got %d %s", CodeRequestTooLarge, rr.Code, body.Code)
+			}
+		})
+	}
+}
+
+func TestProcessReceiptValidationProfile(t *testing.T) {
+	store := model.NewMemoryStore()
+	input := `{
+        "retailer": "Target",
+        "purchaseDate": "2024-02-07",
+        "purchaseTime": "13:45",
+        "items": [{"shortDescription": "Mountain Dew", "price": "1.5"}],
+        "total": "1.5"
+    }`
+	testCases := []struct {
+		name       string
+		target     string
+		header     string
+		statusCode int
+	}{
+		{"Deployment default (lenient)", "/receipts/process", "", http.StatusOK},
+		{"Strict via query", "/receipts/process?validation=strict", "", http.StatusBadRequest},
+		{"Strict via header", "/receipts/process", "strict", http.StatusBadRequest},
+		{"Unknown profile", "/receipts/process?validation=loose", "", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.target, bytes.NewBufferString(input))
+			if tc.header != "" {
+				req.Header.Set("X-Validation-Profile", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			NewHandler(store).ProcessReceipt(rr, req)
+			if rr.Code != tc.statusCode {
+				t.Errorf("Expected status code %d, got %d: %s", tc.statusCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
 }
 
 func TestGetReceiptPoints(t *testing.T) {
-    // Create and store a receipt
-    receipt := createTestReceipt()
-    receipt.GenerateUniqueID()
-    receipt.CalculatePoints()
-    model.AddReceipt(receipt)
-    
-    // Test getting points
-    req := httptest.NewRequest("GET", "/receipts/"+receipt.ID+"/points", nil)
-    rr := httptest.NewRecorder()
-    
-    GetReceiptPoints(rr, req, receipt.ID)
-    
-    // Check status code
-    if status := rr.Code; status != http.StatusOK {
-        t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
-    }
-    
-    // Verify response
-    var response struct {
-        Points uint `json:"points"`
-    }
-    err := json.Unmarshal(rr.Body.Bytes(), &response)
-    if err != nil {
-        t.Errorf("Failed to parse response: %v", err)
-    }
+	store := model.NewMemoryStore()
+	// Create and store a receipt
+	receipt := createTestReceipt()
+	receipt.GenerateUniqueID()
+	receipt.CalculatePoints()
+	store.Put(receipt)
+
+	// Test getting points
+	req := httptest.NewRequest("GET", "/receipts/"+receipt.ID+"/points", nil)
+	rr := httptest.NewRecorder()
+
+	NewRouter(store).ServeHTTP(rr, req)
+
+	// Check status code
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Verify response
+	var response struct {
+		Points uint `json:"points"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &response)
+	if err != nil {
+		t.Errorf("Failed to parse response: %v", err)
+	}
 }
 
 // Add this test to verify receipt validation
 func TestReceiptValidation(t *testing.T) {
-    receipt := createTestReceipt()
-    err := receipt.ValidateReceipt()
-    if err != nil {
-        t.Errorf("Receipt validation failed: %v", err)
-    }
+	receipt := createTestReceipt()
+	err := receipt.ValidateReceipt()
+	if err != nil {
+		t.Errorf("Receipt validation failed: %v", err)
+	}
 }
 
+func TestRouterMethodNotAllowed(t *testing.T) {
+	store := model.NewMemoryStore()
+	testCases := []struct {
+		method     string
+		path       string
+		statusCode int
+		allow      string
+	}{
+		{"GET", "/receipts/process", http.StatusMethodNotAllowed, "OPTIONS, POST"},
+		{"POST", "/metrics", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{"POST", "/receipts/some-id", http.StatusMethodNotAllowed, "DELETE, GET, HEAD, OPTIONS, PATCH, PUT"},
+		{"DELETE", "/receipts/some-id/points", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/receipts/some-id/points", http.StatusNoContent, "GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/receipts/process", http.StatusNoContent, "OPTIONS, POST"},
+		{"PUT", "/receipts/process", http.StatusMethodNotAllowed, "OPTIONS, POST"},
+		{"GET", "/receipts/batch", http.StatusMethodNotAllowed, "OPTIONS, POST"},
+		{"DELETE", "/v1/receipts/export", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{"GET", "/v2/receipts/process", http.StatusMethodNotAllowed, "OPTIONS, POST"},
+		{"DELETE", "/v2/receipts/some-id", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{"GET", "/receipts/some-id/unknown", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			NewRouter(store).ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if rr.Code != tc.statusCode {
+				t.Errorf("Expected status code %d, got %d", tc.statusCode, rr.Code)
+			}
+			if allow := rr.Header().Get("Allow"); allow != tc.allow {
+				t.Errorf("Expected Allow %q, got %q", tc.allow, allow)
+			}
+			if tc.statusCode == http.StatusMethodNotAllowed {
+				var body problem
+				json.NewDecoder(rr.Body).Decode(&body)
+				if body.Code != CodeMethodNotAllowed {
+					t.Errorf("Expected code %s, got %s", CodeMethodNotAllowed, body.Code)
+				}
+			}
+		})
+	}
+}
 
 func TestNotFoundHandler(t *testing.T) {
-    testData := GetNotFoundTestData()
-
-    for _, tc := range testData.Cases {
-        t.Run(tc.Name, func(t *testing.T) {
-            req := httptest.NewRequest("GET", tc.Path, nil)
-            rr := httptest.NewRecorder()
-
-            NotFoundHandler(rr, req)
-
-            if status := rr.Code; status != tc.ExpectedStatus {
-                t.Errorf("handler returned wrong status code: got %v want %v",
-                    status, tc.ExpectedStatus)
-            }
-
-            var response map[string]string
-            err := json.NewDecoder(rr.Body).Decode(&response)
-            if err != nil {
-                t.Fatalf("Failed to decode response body: %v", err)
-            }
-
-            if response["error"] != tc.ExpectedError {
-                t.Errorf("handler returned unexpected error: got %v want %v",
-                    response["error"], tc.ExpectedError)
-            }
-
-            expectedMessage := fmt.Sprintf("The requested URL %s was not found on this server.", tc.Path)
-            if response["message"] != expectedMessage {
-                t.Errorf("handler returned unexpected message: got %v want %v",
-                    response["message"], expectedMessage)
-            }
-        })
-    }
-}
\ No newline at end of file
+	testData := GetNotFoundTestData()
+
+	for _, tc := range testData.Cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.Path, nil)
+			rr := httptest.NewRecorder()
+
+			NotFoundHandler(rr, req)
+
+			if status := rr.Code; status != tc.ExpectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tc.ExpectedStatus)
+			}
+
+			var response problem
+			err := json.NewDecoder(rr.Body).Decode(&response)
+			if err != nil {
+				t.Fatalf("Failed to decode response body: %v", err)
+			}
+
+			if response.Title != tc.ExpectedError || response.Code != CodeEndpointNotFound {
+				t.Errorf("handler returned unexpected error: got %v (%v) want %v (%v)",
+					response.Title, response.Code, tc.ExpectedError, CodeEndpointNotFound)
+			}
+
+			expectedMessage := fmt.Sprintf("The requested URL %s was not found on this server.", tc.Path)
+			if response.Detail != expectedMessage {
+				t.Errorf("handler returned unexpected message: got %v want %v",
+					response.Detail, expectedMessage)
+			}
+			if response.RequestID == "" || response.RequestID != rr.Header().Get(RequestIDHeader) {
+				t.Errorf("Expected requestId to match the %s header, got %q", RequestIDHeader, response.RequestID)
+			}
+		})
+	}
+}
+
+func TestProcessReceiptBatch(t *testing.T) {
+	batch := `[
+        {"retailer": "Target", "purchaseDate": "2024-02-07", "purchaseTime": "13:45", "items": [{"shortDescription": "Mountain Dew", "price": "1.99"}], "total": "1.99"},
+        {"retailer": "", "purchaseDate": "2024-02-07", "purchaseTime": "13:45", "items": [{"shortDescription": "Mountain Dew", "price": "1.99"}], "total": "1.99"},
+        {"retailer": "Target", "cashier": "Bob"},
+        {"retailer": "Walmart", "purchaseDate": "02/07/2024", "purchaseTime": "13:45", "items": [{"shortDescription": "Gum", "price": "1.00"}], "total": "1.00"}
+    ]`
+
+	post := func(store model.ReceiptStore, target string) (int, batchResponse) {
+		rr := httptest.NewRecorder()
+		NewRouter(store).ServeHTTP(rr, httptest.NewRequest("POST", target, bytes.NewBufferString(batch)))
+		var response batchResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode batch response: %v", err)
+		}
+		return rr.Code, response
+	}
+
+	t.Run("Independent", func(t *testing.T) {
+		store := model.NewMemoryStore()
+		status, response := post(store, "/receipts/batch")
+		if status != http.StatusOK || response.Accepted != 2 || response.Rejected != 2 {
+			t.Fatalf("Expected 200 with 2 accepted / 2 rejected, got %d %+v", status, response)
+		}
+		expectedCodes := []string{"", CodeInvalidReceipt, CodeUnknownField, ""}
+		for i, result := range response.Results {
+			if result.Index != i || result.Code != expectedCodes[i] {
+				t.Errorf("Result %d: expected code %q, got %+v", i, expectedCodes[i], result)
+			}
+			if (result.ID != "") != (expectedCodes[i] == "") {
+				t.Errorf("Result %d: unexpected id %q", i, result.ID)
+			}
+		}
+		if len(response.Results[3].Warnings) == 0 {
+			t.Error("Expected the ambiguous date warning on result 3")
+		}
+		if got := len(store.List()); got != 2 {
+			t.Errorf("Expected 2 stored receipts, got %d", got)
+		}
+	})
+
+	t.Run("Atomic", func(t *testing.T) {
+		store := model.NewMemoryStore()
+		status, response := post(store, "/receipts/batch?atomic=true")
+		if status != http.StatusUnprocessableEntity || response.Accepted != 0 {
+			t.Fatalf("Expected 422 with nothing accepted, got %d %+v", status, response)
+		}
+		if response.Results[0].Code != CodeBatchRolledBack || response.Results[0].ID != "" {
+			t.Errorf("Expected the valid receipt to be rolled back, got %+v", response.Results[0])
+		}
+		if got := len(store.List()); got != 0 {
+			t.Errorf("Expected nothing stored, got %d receipts", got)
+		}
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		defer func(saved int) { MaxBatchSize = saved }(MaxBatchSize)
+		defer func(saved int64) { MaxBatchBytes = saved }(MaxBatchBytes)
+		MaxBatchSize, MaxBatchBytes = 2, 1000
+		receipt := `{"retailer": "Target", "purchaseDate": "2024-02-07", "purchaseTime": "13:45", "items": [{"shortDescription": "Mountain Dew", "price": "1.99"}], "total": "1.99"}`
+		for _, tc := range []struct {
+			name, body string
+			status     int
+			code       string
+		}{
+			// rejected at the third element, before the broken rest is read
+			{"Too many receipts", "[" + receipt + "," + receipt + "," + receipt + ", {broken", http.StatusRequestEntityTooLarge, CodeBatchTooLarge},
+			{"Too many bytes", "[" + receipt + "," + strings.Repeat(" ", 1000) + receipt + "]", http.StatusRequestEntityTooLarge, CodeRequestTooLarge},
+			{"Not an array", receipt, http.StatusBadRequest, CodeMalformedJSON},
+			{"Unterminated", "[" + receipt, http.StatusBadRequest, CodeMalformedJSON},
+		} {
+			rr := httptest.NewRecorder()
+			NewRouter(model.NewMemoryStore()).ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/batch", strings.NewReader(tc.body)))
+			var p problem
+			json.NewDecoder(rr.Body).Decode(&p)
+			if rr.Code != tc.status || p.Code != tc.code {
+				t.Errorf("%s: expected %d %s, got %d %+v", tc.name, tc.status, tc.code, rr.Code, p)
+			}
+		}
+	})
+}
+
+func TestProcessReceiptStream(t *testing.T) {
+	store := model.NewMemoryStore()
+	defer func(saved int) { MaxStreamLineSize = saved }(MaxStreamLineSize)
+	MaxStreamLineSize = 512
+
+	valid := `{"retailer": "Target", "purchaseDate": "2024-02-07", "purchaseTime": "13:45", "items": [{"shortDescription": "Mountain Dew", "price": "1.99"}], "total": "1.99"}`
+	body := valid + "\n" +
+		"\n" +
+		`{"retailer": "", "purchaseDate": "2024-02-07"}` + "\n" +
+		`{"retailer": "` + strings.Repeat("x", 600) + `"}` + "\n" +
+		valid // no trailing newline
+
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/stream", strings.NewReader(body)))
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("Expected application/x-ndjson, got %s", contentType)
+	}
+
+	var results []streamResult
+	decoder := json.NewDecoder(rr.Body)
+	for decoder.More() {
+		var result streamResult
+		if err := decoder.Decode(&result); err != nil {
+			t.Fatalf("Failed to decode result line: %v", err)
+		}
+		results = append(results, result)
+	}
+
+	expected := []struct {
+		line int
+		code string
+	}{{1, ""}, {3, CodeInvalidReceipt}, {4, CodeLineTooLong}, {5, ""}}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %+v", len(expected), results)
+	}
+	for i, e := range expected {
+		if results[i].Line != e.line || results[i].Code != e.code {
+			t.Errorf("Result %d: expected line %d code %q, got %+v", i, e.line, e.code, results[i])
+		}
+	}
+	if got := len(store.List()); got != 2 {
+		t.Errorf("Expected 2 stored receipts, got %d", got)
+	}
+}
+
+func TestExportReceipts(t *testing.T) {
+	store := model.NewMemoryStore()
+	for i := 0; i < 3; i++ {
+		receipt := createTestReceipt()
+		receipt.GenerateUniqueID()
+		receipt.CaptureRaw()
+		store.Put(receipt)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/export", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 NDJSON lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var receipt model.Receipt
+		if err := json.Unmarshal([]byte(line), &receipt); err != nil {
+			t.Fatalf("Failed to decode export line: %v", err)
+		}
+		if receipt.ID == "" || receipt.Raw != nil {
+			t.Errorf("Expected an exported receipt without raw input, got %+v", receipt)
+		}
+	}
+}
+
+func TestImportReceiptsCSVFlattened(t *testing.T) {
+	store := model.NewMemoryStore()
+	// consecutive rows with the same receipt columns form one receipt
+	body := "Store,purchaseDate,purchaseTime,total,shortDescription,price\n" +
+		"Target,2024-02-07,13:45,3.24,Mountain Dew,1.99\n" +
+		"Target,2024-02-07,13:45,3.24,Gatorade,1.25\n" +
+		"Walmart,2024-02-08,09:15,abc,Milk,2.50\n"
+
+	req := httptest.NewRequest("POST", "/receipts/import?map=retailer:Store", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response csvImportResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Accepted != 1 || response.Rejected != 1 || len(response.Results) != 2 {
+		t.Fatalf("Expected 1 accepted and 1 rejected, got %+v", response)
+	}
+	if !reflect.DeepEqual(response.Results[0].Rows, []int{2, 3}) || response.Results[0].ID == "" {
+		t.Errorf("Expected rows 2-3 accepted, got %+v", response.Results[0])
+	}
+
+	rejected := response.Results[1]
+	if len(rejected.Errors) == 0 {
+		t.Fatalf("Expected row errors, got %+v", rejected)
+	}
+	expected := csvFieldError{File: "file", Row: 4, Column: "total", Code: model.CodeInvalidFormat}
+	got := rejected.Errors[0]
+	got.Message = ""
+	if got != expected {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestImportReceiptsCSVMalformedRow(t *testing.T) {
+	// a bare quote in the first field leaves the reader with no field positions
+	body := "retailer,purchaseDate,purchaseTime,total,shortDescription,price\n" +
+		"a\"b,2022-01-01,13:01,6.49,Mountain Dew 12PK,6.49\n" +
+		"Target,2022-01-01,13:01,6.49,Mountain Dew 12PK,6.49\n"
+
+	req := httptest.NewRequest("POST", "/receipts/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	NewRouter(model.NewMemoryStore()).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response csvImportResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Accepted != 1 || len(response.Results) != 1 || !reflect.DeepEqual(response.Results[0].Rows, []int{3}) {
+		t.Errorf("Expected the valid row 3 accepted, got %+v", response)
+	}
+	if len(response.RowErrors) != 1 || response.RowErrors[0].Row != 2 || response.RowErrors[0].Code != CodeInvalidCSV {
+		t.Errorf("Expected row 2 reported as %s, got %+v", CodeInvalidCSV, response.RowErrors)
+	}
+}
+
+func TestImportReceiptsCSVLinked(t *testing.T) {
+	store := model.NewMemoryStore()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("receipts", "receipts.csv")
+	part.Write([]byte("receiptRef,retailer,purchasedAt,total\n" +
+		"r1,Target,2024-02-07T13:45:00-05:00,1.99\n" +
+		"r2,Walmart,2024-02-08T09:15:00Z,2.50\n"))
+	part, _ = writer.CreateFormFile("items", "items.csv")
+	part.Write([]byte("receiptRef,shortDescription,price\n" +
+		"r1,Mountain Dew,1.99\n" +
+		"r2,Milk,\n" +
+		"r3,Orphan,1.00\n"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/receipts/import", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response csvImportResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Layout != "linked" || response.Accepted != 1 || response.Rejected != 1 {
+		t.Fatalf("Expected linked layout with 1 accepted and 1 rejected, got %+v", response)
+	}
+	if len(response.RowErrors) != 1 || response.RowErrors[0].Code != CodeUnknownReceiptRef || response.RowErrors[0].Row != 4 {
+		t.Errorf("Expected the orphan item row to be reported, got %+v", response.RowErrors)
+	}
+
+	var priceError *csvFieldError
+	for i, e := range response.Results[1].Errors {
+		if e.Column == "price" {
+			priceError = &response.Results[1].Errors[i]
+		}
+	}
+	if priceError == nil || priceError.File != "items" || priceError.Row != 3 {
+		t.Errorf("Expected the missing price on items row 3, got %+v", response.Results[1].Errors)
+	}
+}
+
+func TestImportReceiptsCSVMissingColumns(t *testing.T) {
+	store := model.NewMemoryStore()
+	req := httptest.NewRequest("POST", "/receipts/import", strings.NewReader("retailer,total\nTarget,1.00\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	var p problem
+	json.NewDecoder(rr.Body).Decode(&p)
+	if p.Code != CodeInvalidCSV || !strings.Contains(p.Detail, "shortDescription") {
+		t.Errorf("Expected missing columns to be named, got %+v", p)
+	}
+}
+
+func TestExportReceiptsCSV(t *testing.T) {
+	store := model.NewMemoryStore()
+	receipt := createTestReceipt()
+	receipt.GenerateUniqueID()
+	receipt.CalculatePoints()
+	store.Put(receipt)
+
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/export?format=csv", nil))
+	if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/csv") {
+		t.Fatalf("Expected text/csv, got %s", contentType)
+	}
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	if len(records) != 1+len(receipt.Items) {
+		t.Fatalf("Expected a header and %d item rows, got %d rows", len(receipt.Items), len(records))
+	}
+	if !reflect.DeepEqual(records[0], csvExportHeader) {
+		t.Errorf("Unexpected header %v", records[0])
+	}
+
+	// the breakdown columns add up to the points column
+	sum := 0
+	for _, column := range records[1][7:14] {
+		points, _ := strconv.Atoi(column)
+		sum += points
+	}
+	if records[1][0] != receipt.ID || records[1][6] != strconv.Itoa(sum) {
+		t.Errorf("Expected breakdown to sum to points, got %v", records[1])
+	}
+}
+
+func TestExportReceiptsCSVStoredValues(t *testing.T) {
+	store := model.NewMemoryStore()
+	receipt := createTestReceipt()
+	receipt.GenerateUniqueID()
+	receipt.CalculatePoints()
+	receipt.Retailer = `=HYPERLINK("http://example.com")`
+	receipt.Items[0].ShortDescription = "@SUM(A1)"
+	// as scored under an older calendar
+	receipt.Breakdown = model.PointsBreakdown{Calendar: 7}
+	receipt.Points = 7
+	store.Put(receipt)
+
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/export?format=csv", nil))
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	row := records[1]
+	if row[1] != `'=HYPERLINK("http://example.com")` || row[15] != "'@SUM(A1)" {
+		t.Errorf("Expected formulas to be quoted, got retailer %q and description %q", row[1], row[15])
+	}
+	if !reflect.DeepEqual(row[6:14], []string{"7", "0", "0", "0", "0", "0", "7", "0"}) {
+		t.Errorf("Expected the stored points and breakdown, got %v", row[6:14])
+	}
+}
+
+func TestImportReceiptsCSVLimits(t *testing.T) {
+	body := "retailer,purchaseDate,purchaseTime,total,shortDescription,price\n" +
+		"Target,2022-01-01,13:01,6.49,Mountain Dew 12PK,6.49\n" +
+		"Target,2022-01-02,13:01,6.49,Mountain Dew 12PK,6.49\n" +
+		"Target,2022-01-03,13:01,6.49,Mountain Dew 12PK,6.49\n"
+	defer func(size int, bytes int64) { MaxBatchSize, MaxBatchBytes = size, bytes }(MaxBatchSize, MaxBatchBytes)
+
+	testCases := []struct {
+		name  string
+		size  int
+		bytes int64
+		code  string
+	}{
+		{"Too many rows", 2, 1 << 20, CodeBatchTooLarge},
+		{"Too many bytes", 100, 64, CodeRequestTooLarge},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			MaxBatchSize, MaxBatchBytes = tc.size, tc.bytes
+			req := httptest.NewRequest("POST", "/receipts/import", strings.NewReader(body))
+			req.Header.Set("Content-Type", "text/csv")
+			rr := httptest.NewRecorder()
+			NewRouter(model.NewMemoryStore()).ServeHTTP(rr, req)
+
+			var p problem
+			json.NewDecoder(rr.Body).Decode(&p)
+			if rr.Code != http.StatusRequestEntityTooLarge || p.Code != tc.code {
+				t.Errorf("Expected 413 %s, got %d %s", tc.code, rr.Code, p.Code)
+			}
+		})
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	store := model.NewMemoryStore()
+	receipt := createTestReceipt()
+	receipt.GenerateUniqueID()
+	receipt.CalculatePoints()
+	store.Put(receipt)
+
+	testCases := []struct {
+		name        string
+		path        string
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"no Accept header", "/receipts/" + receipt.ID, "", http.StatusOK, "application/json"},
+		{"wildcard", "/receipts/" + receipt.ID, "*/*", http.StatusOK, "application/json"},
+		{"xml", "/receipts/" + receipt.ID, "application/xml", http.StatusOK, "application/xml; charset=utf-8"},
+		{"q values", "/receipts/" + receipt.ID, "application/json;q=0.5, text/csv", http.StatusOK, "text/csv; charset=utf-8"},
+		{"type wildcard", "/receipts/" + receipt.ID + "/points", "text/*", http.StatusOK, "text/xml; charset=utf-8"},
+		{"unsupported", "/receipts/" + receipt.ID, "application/pdf", http.StatusNotAcceptable, "application/problem+json"},
+		{"excluded with q=0", "/receipts/", "application/json;q=0", http.StatusNotAcceptable, "application/problem+json"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rr := httptest.NewRecorder()
+			NewRouter(store).ServeHTTP(rr, req)
+			if rr.Code != tc.status {
+				t.Errorf("Expected status code %d, got %d", tc.status, rr.Code)
+			}
+			if contentType := rr.Header().Get("Content-Type"); contentType != tc.contentType {
+				t.Errorf("Expected Content-Type %s, got %s", tc.contentType, contentType)
+			}
+		})
+	}
+
+	t.Run("xml body round-trips", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/receipts/"+receipt.ID, nil)
+		req.Header.Set("Accept", "application/xml")
+		rr := httptest.NewRecorder()
+		NewRouter(store).ServeHTTP(rr, req)
+
+		var decoded model.Receipt
+		if err := xml.NewDecoder(rr.Body).Decode(&decoded); err != nil {
+			t.Fatalf("Failed to decode XML: %v", err)
+		}
+		if decoded.ID != receipt.ID || decoded.Points != receipt.Points || !reflect.DeepEqual(decoded.Items, receipt.Items) {
+			t.Errorf("Expected %+v, got %+v", receipt, decoded)
+		}
+	})
+}
+
+func TestProcessReceiptXML(t *testing.T) {
+	store := model.NewMemoryStore()
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<receipt>
+  <retailer>Target</retailer>
+  <purchaseDate>2024-02-07</purchaseDate>
+  <purchaseTime>13:45</purchaseTime>
+  <items>
+    <item><shortDescription>Mountain Dew</shortDescription><price>1.99</price></item>
+    <item><shortDescription>Gatorade</shortDescription><price>1.25</price></item>
+  </items>
+  <total>3.24</total>
+</receipt>`
+
+	req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Accept", "application/xml")
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response processResponse
+	if err := xml.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode XML response: %v", err)
+	}
+	stored, exists := store.Get(response.ID)
+	if !exists || len(stored.Items) != 2 || stored.Total != "3.24" {
+		t.Errorf("Expected the XML receipt to be stored, got %+v", stored)
+	}
+
+	req = httptest.NewRequest("POST", "/receipts/process", strings.NewReader("<receipt><retailer>Target"))
+	req.Header.Set("Content-Type", "text/xml")
+	rr = httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, req)
+	var p problem
+	json.NewDecoder(rr.Body).Decode(&p)
+	if rr.Code != http.StatusBadRequest || p.Code != CodeMalformedXML {
+		t.Errorf("Expected %s, got %d %+v", CodeMalformedXML, rr.Code, p)
+	}
+
+	// elements that are not in the schema are refused like unknown JSON fields
+	for _, test := range []struct {
+		path, body, code, pointer string
+	}{
+		{"/receipts/process", strings.Replace(body, "<total>", "<coupon>SAVE</coupon><total>", 1), CodeUnknownField, "/coupon"},
+		{"/receipts/process", strings.Replace(body, "<price>1.25</price>", "<price>1.25</price><discount>1</discount>", 1), CodeUnknownField, "/items/1/discount"},
+		{"/receipts/process", strings.Replace(body, "</items>", "<note/></items>", 1), CodeUnknownField, "/items/note"},
+		{"/receipts/process", strings.ReplaceAll(body, "receipt>", "order>"), CodeMalformedXML, ""},
+		{"/v2/receipts/process", strings.Replace(strings.ReplaceAll(body, "<price>", "<unitPrice>"), "</price>", "</unitPrice><price>1</price>", 1), CodeUnknownField, "/items/0/price"},
+	} {
+		req = httptest.NewRequest("POST", test.path, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/xml")
+		rr = httptest.NewRecorder()
+		NewRouter(store).ServeHTTP(rr, req)
+		p = problem{}
+		json.NewDecoder(rr.Body).Decode(&p)
+		if rr.Code != http.StatusBadRequest || p.Code != test.code {
+			t.Errorf("%s: expected a 400 %s, got %d %+v", test.pointer, test.code, rr.Code, p)
+		} else if test.pointer != "" && (len(p.Errors) != 1 || p.Errors[0].Pointer != test.pointer) {
+			t.Errorf("Expected the error at %s, got %+v", test.pointer, p.Errors)
+		}
+	}
+}
+
+func TestOpenAPIDocs(t *testing.T) {
+	store := model.NewMemoryStore()
+	data, err := os.ReadFile("../api.yml")
+	if err != nil {
+		t.Fatalf("Failed to read api.yml: %v", err)
+	}
+	defer func(saved *openapi.Spec) { APISpec = saved }(APISpec)
+	if APISpec, err = openapi.Parse(data); err != nil {
+		t.Fatalf("Failed to parse api.yml: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.yml", nil))
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), data) {
+		t.Errorf("Expected the spec to be served as written, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/docs", nil))
+	if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Errorf("Expected an HTML page, got %s", contentType)
+	}
+	for _, expected := range []string{"/receipts/process", "/receipts/{id}/points", `id="schema-Receipt"`, "shortDescription"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("Expected the docs page to mention %s", expected)
+		}
+	}
+}
+
+func TestVersionedRoutes(t *testing.T) {
+	store := model.NewMemoryStore()
+	body := `{"retailer": "Target", "purchaseDate": "2024-02-07", "purchaseTime": "13:45", "items": [{"shortDescription": "Mountain Dew", "price": "1.99"}], "total": "1.99"}`
+
+	for _, path := range []string{"/receipts/process", "/v1/receipts/process"} {
+		rr := httptest.NewRecorder()
+		NewRouter(store).ServeHTTP(rr, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status code %d, got %d", path, http.StatusOK, rr.Code)
+		}
+		if rr.Header().Get("Deprecation") == "" || rr.Header().Get("Sunset") == "" {
+			t.Errorf("%s: expected Deprecation and Sunset headers, got %v", path, rr.Header())
+		}
+		if link := rr.Header().Get("Link"); link != `</v2/receipts/process>; rel="successor-version"` {
+			t.Errorf("%s: unexpected successor Link %q", path, link)
+		}
+	}
+
+	// v1-only endpoints have no successor and are not deprecated
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/v1/receipts/export", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Deprecation") != "" {
+		t.Errorf("Expected /v1/receipts/export without deprecation, got %d %v", rr.Code, rr.Header())
+	}
+
+	// v2 fields are not part of the v1 contract
+	rr = httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", strings.NewReader(`{"currency": "USD"}`)))
+	var p problem
+	json.NewDecoder(rr.Body).Decode(&p)
+	if p.Code != CodeUnknownField {
+		t.Errorf("Expected v1 to reject currency, got %+v", p)
+	}
+}
+
+func TestProcessReceiptV2(t *testing.T) {
+	store := model.NewMemoryStore()
+	body := `{
+        "retailer": "Target",
+        "purchasedAt": "2024-02-07T13:45:00-05:00",
+        "currency": "EUR",
+        "memberId": "member-42",
+        "items": [
+            {"shortDescription": "Mountain Dew", "quantity": 3, "unitPrice": "2.00"},
+            {"shortDescription": "Gatorade", "unitPrice": "2.25"}
+        ],
+        "total": "8.25"
+    }`
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("POST", "/v2/receipts/process", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Deprecation") != "" {
+		t.Errorf("Expected no Deprecation header on v2")
+	}
+	var created processResponseV2
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	stored, _ := store.Get(created.ID)
+	if created.Points == 0 || created.Points != stored.Points {
+		t.Errorf("Expected the response to carry the stored points, got %d and %d", created.Points, stored.Points)
+	}
+	// v1 sees the line total as the item price
+	if stored.Items[0].Price != "6.00" || stored.Currency != "EUR" || stored.MemberID != "member-42" {
+		t.Errorf("Unexpected stored receipt %+v", stored)
+	}
+
+	rr = httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/v2/receipts/"+created.ID, nil))
+	var receipt receiptV2
+	json.NewDecoder(rr.Body).Decode(&receipt)
+	expectedItems := []itemV2{
+		{ShortDescription: "Mountain Dew", Quantity: 3, UnitPrice: "2.00", Price: "6.00"},
+		{ShortDescription: "Gatorade", Quantity: 1, UnitPrice: "2.25", Price: "2.25"},
+	}
+	if !reflect.DeepEqual(receipt.Items, expectedItems) || receipt.Currency != "EUR" || receipt.PurchaseOffset != "-05:00" {
+		t.Errorf("Unexpected v2 receipt %+v", receipt)
+	}
+
+	rr = httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/v2/receipts/"+created.ID+"/points", nil))
+	var points pointsResponseV2
+	json.NewDecoder(rr.Body).Decode(&points)
+	if points.Points != created.Points || points.Breakdown.Sum() != points.Points {
+		t.Errorf("Expected the breakdown to add up to %d, got %+v", created.Points, points)
+	}
+
+	// a v1 receipt reads as USD with quantity 1
+	v1 := createTestReceipt()
+	v1.GenerateUniqueID()
+	store.Put(v1)
+	rr = httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/v2/receipts/?limit=10", nil))
+	var page receiptPageV2
+	json.NewDecoder(rr.Body).Decode(&page)
+	if len(page.Receipts) != 2 {
+		t.Fatalf("Expected 2 receipts, got %+v", page)
+	}
+	for _, r := range page.Receipts {
+		if r.ID == v1.ID && (r.Currency != DefaultCurrency || r.Items[0].Quantity != 1) {
+			t.Errorf("Expected a v1 receipt to default to %s and quantity 1, got %+v", DefaultCurrency, r)
+		}
+	}
+}
+
+func TestProcessReceiptV2Errors(t *testing.T) {
+	store := model.NewMemoryStore()
+	body := `{"retailer": "Target", "purchaseDate": "2024-02-07", "purchaseTime": "13:45", "currency": "usd",
+        "items": [{"shortDescription": "Dew", "quantity": 0, "unitPrice": "1.99"}, {"shortDescription": "Gatorade", "unitPrice": "abc"}],
+        "total": "1.99"}`
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("POST", "/v2/receipts/process", strings.NewReader(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	var p problem
+	json.NewDecoder(rr.Body).Decode(&p)
+	pointers := map[string]string{}
+	for _, e := range p.Errors {
+		pointers[e.Pointer] = e.Code
+	}
+	expected := map[string]string{
+		"/currency":          model.CodePatternMismatch,
+		"/items/0/quantity":  model.CodeOutOfRange,
+		"/items/1/unitPrice": model.CodeInvalidFormat,
+	}
+	for pointer, code := range expected {
+		if pointers[pointer] != code {
+			t.Errorf("Expected %s at %s, got %v", code, pointer, pointers)
+		}
+	}
+	if _, ok := pointers["/items/1/price"]; ok {
+		t.Errorf("Expected v1 pointers to be renamed, got %v", pointers)
+	}
+}
+
+// failingStore is a ReceiptStore whose writes always fail
+type failingStore struct {
+	*model.MemoryStore
+}
+
+func (failingStore) Put(model.Receipt) error {
+	return fmt.Errorf("disk full")
+}
+
+func TestHandlerStoreInjection(t *testing.T) {
+	body := `{"retailer": "Target", "purchaseDate": "2024-02-07", "purchaseTime": "13:45", "items": [{"shortDescription": "Mountain Dew", "price": "1.99"}], "total": "1.99"}`
+
+	// two handlers never see each other's receipts
+	first, second := model.NewMemoryStore(), model.NewMemoryStore()
+	rr := httptest.NewRecorder()
+	NewRouter(first).ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", strings.NewReader(body)))
+	if len(first.List()) != 1 || len(second.List()) != 0 {
+		t.Errorf("Expected the receipt only in the first store, got %d and %d", len(first.List()), len(second.List()))
+	}
+
+	rr = httptest.NewRecorder()
+	NewRouter(failingStore{model.NewMemoryStore()}).ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", strings.NewReader(body)))
+	var p problem
+	json.NewDecoder(rr.Body).Decode(&p)
+	if rr.Code != http.StatusInternalServerError || p.Code != CodeInternalError {
+		t.Errorf("Expected a store failure to be a 500 %s, got %d %+v", CodeInternalError, rr.Code, p)
+	}
+	if strings.Contains(p.Detail, "disk full") {
+		t.Errorf("Expected the store error not to leak, got %q", p.Detail)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	store := model.NewBoundedStore(model.NewMemoryStore(), model.BoundedStoreOptions{MaxReceipts: 1})
+	defer store.Close()
+	router := NewRouter(store)
+
+	var ids []string
+	for _, retailer := range []string{"Target", "Walgreens"} {
+		body := `{"retailer": "` + retailer + `", "purchaseDate": "2024-02-07", "purchaseTime": "13:45", "items": [{"shortDescription": "Mountain Dew", "price": "1.99"}], "total": "1.99"}`
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/receipts/process", strings.NewReader(body)))
+		var response map[string]string
+		json.NewDecoder(rr.Body).Decode(&response)
+		ids = append(ids, response["id"])
+	}
+
+	// the first receipt was evicted, and is gone like any unknown ID
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/receipts/"+ids[0], nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected an evicted receipt to be a 404, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Expected a text/plain 200, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	for _, line := range []string{"receipts_stored 1", `receipts_evicted_total{reason="max_receipts"} 1`, `receipts_evicted_total{reason="ttl"} 0`} {
+		if !strings.Contains(rr.Body.String(), line+"\n") {
+			t.Errorf("Expected the line %q, got:\n%s", line, rr.Body.String())
+		}
+	}
+
+	// a store without its own counters still reports its size
+	rr = httptest.NewRecorder()
+	NewRouter(model.NewMemoryStore()).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rr.Body.String(), "receipts_stored 0\n") || strings.Contains(rr.Body.String(), "receipts_evicted") {
+		t.Errorf("Unexpected metrics for a memory store:\n%s", rr.Body.String())
+	}
+}
+
+func TestDuplicateReceipts(t *testing.T) {
+	defer func(saved model.DuplicatePolicy) { model.ActiveDuplicatePolicy = saved }(model.ActiveDuplicatePolicy)
+	receipt := func(purchaseTime string) string {
+		return `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "` + purchaseTime + `", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"}`
+	}
+	post := func(router http.Handler, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return rr
+	}
+
+	t.Run("Reject", func(t *testing.T) {
+		model.ActiveDuplicatePolicy = model.DuplicateReject
+		store := model.NewIndexedStore(model.NewMemoryStore())
+		router := NewRouter(store)
+
+		var created processResponse
+		json.NewDecoder(post(router, "/receipts/process", receipt("13:01")).Body).Decode(&created)
+
+		for _, purchaseTime := range []string{"13:01", "1:02 PM"} {
+			rr := post(router, "/receipts/process", receipt(purchaseTime))
+			var p problem
+			json.NewDecoder(rr.Body).Decode(&p)
+			if rr.Code != http.StatusConflict || p.Code != CodeDuplicateReceipt || p.OriginalID != created.ID {
+				t.Errorf("%s: expected a 409 %s naming %s, got %d %+v", purchaseTime, CodeDuplicateReceipt, created.ID, rr.Code, p)
+			}
+		}
+		if rr := post(router, "/receipts/process", receipt("13:03")); rr.Code != http.StatusOK {
+			t.Errorf("Expected a receipt two minutes later to be accepted, got %d", rr.Code)
+		}
+		if got := len(store.List()); got != 2 {
+			t.Errorf("Expected 2 stored receipts, got %d", got)
+		}
+
+		// v2 and batches go through the same check, including within one batch
+		if rr := post(router, "/v2/receipts/process", `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"shortDescription": "Mountain Dew 12PK", "unitPrice": "6.49"}], "total": "6.49"}`); rr.Code != http.StatusConflict {
+			t.Errorf("Expected a v2 duplicate to be a 409, got %d", rr.Code)
+		}
+		rr := post(router, "/receipts/batch", "["+receipt("09:00")+","+receipt("09:00")+"]")
+		var batch batchResponse
+		json.NewDecoder(rr.Body).Decode(&batch)
+		if batch.Accepted != 1 || batch.Results[1].Code != CodeDuplicateReceipt || batch.Results[1].OriginalID != batch.Results[0].ID {
+			t.Errorf("Expected the second receipt of the batch rejected as a duplicate of the first, got %+v", batch)
+		}
+		rr = post(router, "/receipts/batch?atomic=true", "["+receipt("10:00")+","+receipt("13:01")+"]")
+		if rr.Code != http.StatusConflict || len(store.List()) != 3 {
+			t.Errorf("Expected an atomic batch with a duplicate to be a 409 storing nothing, got %d with %d stored", rr.Code, len(store.List()))
+		}
+	})
+
+	t.Run("Flag", func(t *testing.T) {
+		model.ActiveDuplicatePolicy = model.DuplicateFlag
+		router := NewRouter(model.NewMemoryStore())
+		post(router, "/receipts/process", receipt("13:01"))
+
+		var flagged processResponse
+		rr := post(router, "/receipts/process", receipt("13:00"))
+		json.NewDecoder(rr.Body).Decode(&flagged)
+		if rr.Code != http.StatusOK || len(flagged.Warnings) != 1 || flagged.Warnings[0].Code != model.WarningPossibleDuplicate {
+			t.Errorf("Expected a 200 with a %s warning, got %d %+v", model.WarningPossibleDuplicate, rr.Code, flagged)
+		}
+	})
+
+	t.Run("Accept", func(t *testing.T) {
+		model.ActiveDuplicatePolicy = model.DuplicateAccept
+		store := model.NewMemoryStore()
+		router := NewRouter(store)
+		for i := 0; i < 2; i++ {
+			if rr := post(router, "/receipts/process", receipt("13:01")); rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "warnings") {
+				t.Errorf("Expected a plain 200, got %d %s", rr.Code, rr.Body.String())
+			}
+		}
+		if got := len(store.List()); got != 2 {
+			t.Errorf("Expected both receipts stored, got %d", got)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		model.ActiveDuplicatePolicy = model.DuplicateReject
+		store := &retailerGate{MemoryStore: model.NewMemoryStore(), retailer: "Target", held: make(chan struct{}, 2), release: make(chan struct{})}
+		router := NewRouter(store)
+		codes := make(chan int, 2)
+		for i := 0; i < 2; i++ {
+			go func() { codes <- post(router, "/receipts/process", receipt("13:01")).Code }()
+		}
+
+		// a different receipt does not wait for the Target one being stored
+		<-store.held
+		done := make(chan int)
+		go func() {
+			done <- post(router, "/receipts/process", strings.Replace(receipt("13:01"), "Target", "Walmart", 1)).Code
+		}()
+		select {
+		case code := <-done:
+			if code != http.StatusOK {
+				t.Errorf("Expected the other receipt to be stored, got %d", code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected a receipt with other contents not to wait for the duplicate check")
+		}
+
+		close(store.release)
+		if a, b := <-codes, <-codes; a+b != http.StatusOK+http.StatusConflict {
+			t.Errorf("Expected one copy stored and the other a 409, got %d and %d", a, b)
+		}
+	})
+}
+
+// retailerGate holds a Put for one retailer's receipts, signalling held,
+// until release is closed
+type retailerGate struct {
+	*model.MemoryStore
+	retailer string
+	held     chan struct{}
+	release  chan struct{}
+}
+
+func (s *retailerGate) Put(receipt model.Receipt) error {
+	if receipt.Retailer == s.retailer {
+		s.held <- struct{}{}
+		<-s.release
+	}
+	return s.MemoryStore.Put(receipt)
+}
+
+// slowStore holds every Put until release is closed, counting them
+type slowStore struct {
+	*model.MemoryStore
+	release chan struct{}
+	puts    atomic.Int32
+	fail    atomic.Bool
+}
+
+func (s *slowStore) Put(receipt model.Receipt) error {
+	<-s.release
+	s.puts.Add(1)
+	if s.fail.Swap(false) {
+		return fmt.Errorf("disk full")
+	}
+	return s.MemoryStore.Put(receipt)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	body := `{"retailer": "Target", "purchaseDate": "2024-02-07", "purchaseTime": "13:45", "items": [{"shortDescription": "Mountain Dew", "price": "1.99"}], "total": "1.99"}`
+	store := &slowStore{MemoryStore: model.NewMemoryStore(), release: make(chan struct{})}
+	router := NewRouter(store)
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// concurrent repeats wait for the first and get its response
+	results := make(chan *httptest.ResponseRecorder, 5)
+	for i := 0; i < 5; i++ {
+		go func() { results <- post("key-1", body) }()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(store.release)
+	var ids []string
+	replayed := 0
+	for i := 0; i < 5; i++ {
+		rr := <-results
+		var response processResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d %s", rr.Code, rr.Body.String())
+		}
+		ids = append(ids, response.ID)
+		if rr.Header().Get("Idempotent-Replayed") == "true" {
+			replayed++
+		}
+	}
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Errorf("Expected every repeat to get ID %s, got %v", ids[0], ids)
+			break
+		}
+	}
+	if got := store.puts.Load(); got != 1 || replayed != 4 {
+		t.Errorf("Expected 1 receipt stored and 4 replays, got %d stored and %d replays", got, replayed)
+	}
+
+	t.Run("Same key, different body", func(t *testing.T) {
+		rr := post("key-1", strings.Replace(body, "13:45", "13:46", 1))
+		var p problem
+		json.NewDecoder(rr.Body).Decode(&p)
+		if rr.Code != http.StatusUnprocessableEntity || p.Code != CodeIdempotencyKeyReused {
+			t.Errorf("Expected a 422 %s, got %d %+v", CodeIdempotencyKeyReused, rr.Code, p)
+		}
+	})
+
+	t.Run("Without a key", func(t *testing.T) {
+		before := store.puts.Load()
+		post("", body)
+		post("", body)
+		if got := store.puts.Load() - before; got != 2 {
+			t.Errorf("Expected both requests stored, got %d", got)
+		}
+	})
+
+	t.Run("Server errors are not replayed", func(t *testing.T) {
+		store.fail.Store(true)
+		if rr := post("key-2", body); rr.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected the first attempt to fail, got %d", rr.Code)
+		}
+		if rr := post("key-2", body); rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("Expected the retry to run again, got %d replayed=%q", rr.Code, rr.Header().Get("Idempotent-Replayed"))
+		}
+	})
+
+	t.Run("Expired keys run again", func(t *testing.T) {
+		defer func(saved time.Duration) { IdempotencyWindow = saved }(IdempotencyWindow)
+		IdempotencyWindow = time.Nanosecond
+		post("key-3", body)
+		if rr := post("key-3", strings.Replace(body, "13:45", "13:46", 1)); rr.Code != http.StatusOK {
+			t.Errorf("Expected an expired key to be usable again, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Streams are not buffered", func(t *testing.T) {
+		server := httptest.NewServer(NewRouter(model.NewMemoryStore()))
+		defer server.Close()
+		stream := func(body io.Reader) *http.Response {
+			req, _ := http.NewRequest("POST", server.URL+"/receipts/stream", body)
+			req.Header.Set(IdempotencyKeyHeader, "key-stream")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return resp
+		}
+
+		// the first result arrives while the body is still open
+		pipeReader, pipeWriter := io.Pipe()
+		lines := make(chan string)
+		go func() {
+			resp := stream(pipeReader)
+			defer resp.Body.Close()
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+			close(lines)
+		}()
+		pipeWriter.Write([]byte(body + "\n"))
+		select {
+		case line := <-lines:
+			if !strings.Contains(line, `"line":1`) {
+				t.Errorf("Expected the result for line 1, got %s", line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected a result before the request body was finished")
+		}
+		pipeWriter.Close()
+		for range lines {
+		}
+
+		resp := stream(strings.NewReader(body + "\n"))
+		resp.Body.Close()
+		if resp.Header.Get("Idempotent-Replayed") != "true" {
+			t.Error("Expected the same stream again to be replayed")
+		}
+		resp = stream(strings.NewReader(body + "\n" + body + "\n"))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("Expected a different stream with the same key to be a 422, got %d", resp.StatusCode)
+		}
+
+		// a body the handler rejects without reading still counts
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest("POST", server.URL+"/receipts/stream?validation=bogus", strings.NewReader(strings.Repeat(body+"\n", 1000)))
+			req.Header.Set(IdempotencyKeyHeader, "key-unread")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if replayed := resp.Header.Get("Idempotent-Replayed") == "true"; resp.StatusCode != http.StatusBadRequest || replayed != (i == 1) {
+				t.Errorf("Attempt %d: expected a 400 replayed only the second time, got %d replayed=%v", i+1, resp.StatusCode, replayed)
+			}
+		}
+	})
+
+	t.Run("Long responses are not kept", func(t *testing.T) {
+		defer func(saved int64) { MaxIdempotentResponseSize = saved }(MaxIdempotentResponseSize)
+		MaxIdempotentResponseSize = 256
+		handler := NewHandler(model.NewMemoryStore())
+		router := handler.Router()
+		stream := strings.Repeat(body+"\n", 10)
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("POST", "/receipts/stream", strings.NewReader(stream))
+			req.Header.Set(IdempotencyKeyHeader, "key-long")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "" {
+				t.Errorf("Attempt %d: expected the stream to run, got %d replayed=%q", i+1, rr.Code, rr.Header().Get("Idempotent-Replayed"))
+			}
+			if lines := strings.Count(rr.Body.String(), "\n"); lines != 10 {
+				t.Errorf("Attempt %d: expected 10 results, got %d", i+1, lines)
+			}
+		}
+		if stats := handler.idempotency.stats(); stats.Keys != 0 || stats.Bytes != 0 {
+			t.Errorf("Expected nothing kept, got %d keys and %d bytes", stats.Keys, stats.Bytes)
+		}
+	})
+
+	t.Run("Oldest keys are evicted at the limit", func(t *testing.T) {
+		defer func(saved int) { MaxIdempotencyKeys = saved }(MaxIdempotencyKeys)
+		MaxIdempotencyKeys = 2
+		router := NewRouter(model.NewMemoryStore())
+		send := func(key, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("POST", "/receipts/process", strings.NewReader(body))
+			req.Header.Set(IdempotencyKeyHeader, key)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+		send("a", body)
+		send("b", strings.Replace(body, "13:45", "13:46", 1))
+		send("c", strings.Replace(body, "13:45", "13:47", 1))
+		if rr := send("c", strings.Replace(body, "13:45", "13:47", 1)); rr.Header().Get("Idempotent-Replayed") != "true" {
+			t.Error("Expected the newest key to still be replayed")
+		}
+		if rr := send("a", strings.Replace(body, "13:45", "13:48", 1)); rr.Code != http.StatusOK {
+			t.Errorf("Expected the evicted key to be usable again, got %d %s", rr.Code, rr.Body.String())
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		for _, line := range []string{"idempotency_keys 2", `idempotency_evicted_total{reason="max_keys"} 2`} {
+			if !strings.Contains(rr.Body.String(), line+"\n") {
+				t.Errorf("Expected %q in the metrics, got:\n%s", line, rr.Body.String())
+			}
+		}
+	})
+}
+
+func TestUpdateReceipt(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+	send := func(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	original := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"}`
+	var created processResponse
+	json.NewDecoder(send("POST", "/receipts/process", original, nil).Body).Decode(&created)
+	path := "/receipts/" + created.ID
+
+	if etag := send("GET", path, "", nil).Header().Get("ETag"); etag != `"1"` {
+		t.Fatalf(`Expected a new receipt to have ETag "1", got %q`, etag)
+	}
+
+	// total typo fixed: 12 points become 12 + 50 (round) + 25 (multiple of 0.25)
+	correction := `{"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.00"}], "total": "6.00"}`
+	errorCases := []struct {
+		name       string
+		body       string
+		header     map[string]string
+		statusCode int
+		code       string
+	}{
+		{"No If-Match", correction, nil, http.StatusPreconditionRequired, CodePreconditionRequired},
+		{"Stale If-Match", correction, map[string]string{"If-Match": `"7"`}, http.StatusPreconditionFailed, CodePreconditionFailed},
+		{"Read-only field", `{"points": 1000}`, map[string]string{"If-Match": `"1"`}, http.StatusBadRequest, CodeInvalidReceipt},
+		{"Required field removed", `{"purchaseTime": null}`, map[string]string{"If-Match": `"1"`}, http.StatusBadRequest, CodeInvalidReceipt},
+		{"Not an object", `["total"]`, map[string]string{"If-Match": `"1"`}, http.StatusBadRequest, CodeMalformedJSON},
+		{"XML patch", `<receipt/>`, map[string]string{"If-Match": `"1"`, "Content-Type": "application/xml"}, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := send("PATCH", path, tc.body, tc.header)
+			var p problem
+			json.NewDecoder(rr.Body).Decode(&p)
+			if rr.Code != tc.statusCode || p.Code != tc.code {
+				t.Errorf("Expected %d %s, got %d %+v", tc.statusCode, tc.code, rr.Code, p)
+			}
+		})
+	}
+
+	rr := send("PATCH", path, correction, map[string]string{"If-Match": `"1"`, "Content-Type": MergePatchContentType})
+	var patched model.Receipt
+	json.NewDecoder(rr.Body).Decode(&patched)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` || patched.Points != 87 || patched.Retailer != "Target" || patched.PurchaseTime != "13:01" {
+		t.Fatalf(`Expected a 200 with ETag "2" and 87 points, keeping unpatched fields, got %d %q %+v`, rr.Code, rr.Header().Get("ETag"), patched)
+	}
+
+	// the first edit's ETag is now stale
+	if rr := send("PATCH", path, `{"retailer": "Walmart"}`, map[string]string{"If-Match": `"1"`}); rr.Code != http.StatusPreconditionFailed || rr.Header().Get("ETag") != `"2"` {
+		t.Errorf(`Expected a 412 naming ETag "2", got %d %q`, rr.Code, rr.Header().Get("ETag"))
+	}
+
+	// PUT replaces everything; an even day loses the 6 odd-day points
+	replacement := strings.Replace(original, "2022-01-01", "2022-01-02", 1)
+	if rr := send("PUT", path, replacement, map[string]string{"If-Match": `"2"`}); rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"3"` {
+		t.Fatalf(`Expected a 200 with ETag "3", got %d %q: %s`, rr.Code, rr.Header().Get("ETag"), rr.Body.String())
+	}
+	var points pointsResponse
+	json.NewDecoder(send("GET", path+"/points", "", nil).Body).Decode(&points)
+	if points.Points != 6 {
+		t.Errorf("Expected 6 points after the PUT, got %d", points.Points)
+	}
+
+	var history revisionsResponse
+	json.NewDecoder(send("GET", path+"/revisions", "", nil).Body).Decode(&history)
+	if history.Revision != 3 || len(history.Revisions) != 2 {
+		t.Fatalf("Expected revision 3 with 2 prior versions, got %+v", history)
+	}
+	for i, expected := range []struct {
+		revision uint
+		points   uint
+		total    string
+	}{{1, 12, "6.49"}, {2, 87, "6.00"}} {
+		got := history.Revisions[i]
+		if got.Revision != expected.revision || got.Points != expected.points || got.Receipt.Total != expected.total || got.Receipt.ID != created.ID || got.ReplacedAt.IsZero() {
+			t.Errorf("Expected revision %d with %d points and total %s, got %+v", expected.revision, expected.points, expected.total, got)
+		}
+	}
+}
+
+func TestDeleteReceipt(t *testing.T) {
+	defer func(saved string) { AdminToken = saved }(AdminToken)
+	store := model.NewSoftDeleteStore(model.NewMemoryStore(), model.SoftDeleteStoreOptions{PurgeAfter: time.Hour})
+	defer store.Close()
+
+	// without a token the admin routes do not exist
+	AdminToken = ""
+	rr := httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rr, httptest.NewRequest("GET", "/admin/receipts/deleted", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the admin routes to be off without a token, got %d", rr.Code)
+	}
+
+	AdminToken = "s3cret"
+	router := NewRouter(store)
+	authorization := "Bearer s3cret"
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"}`))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	var created processResponse
+	json.NewDecoder(send("POST", "/receipts/process").Body).Decode(&created)
+	path := "/receipts/" + created.ID
+
+	if rr := send("DELETE", path); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rr.Code)
+	}
+	for _, hidden := range []struct{ method, path string }{
+		{"GET", path},
+		{"GET", path + "/points"},
+		{"GET", "/v2" + path + "/points"},
+		{"PATCH", path},
+		{"DELETE", path},
+	} {
+		if rr := send(hidden.method, hidden.path); rr.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected a deleted receipt to be a 404, got %d", hidden.method, hidden.path, rr.Code)
+		}
+	}
+	var page model.ReceiptPage
+	json.NewDecoder(send("GET", "/receipts/").Body).Decode(&page)
+	if len(page.Receipts) != 0 {
+		t.Errorf("Expected a deleted receipt not to be listed, got %d", len(page.Receipts))
+	}
+	if metrics := send("GET", "/metrics").Body.String(); !strings.Contains(metrics, "receipts_deleted 1\n") {
+		t.Errorf("Expected receipts_deleted 1 in the metrics, got:\n%s", metrics)
+	}
+
+	for _, tc := range []struct {
+		authorization string
+		statusCode    int
+		code          string
+	}{
+		{"", http.StatusUnauthorized, CodeUnauthorized},
+		{"Bearer wrong", http.StatusForbidden, CodeForbidden},
+	} {
+		authorization = tc.authorization
+		rr := send("POST", "/admin/receipts/"+created.ID+"/restore")
+		var p problem
+		json.NewDecoder(rr.Body).Decode(&p)
+		if rr.Code != tc.statusCode || p.Code != tc.code {
+			t.Errorf("Authorization %q: expected %d %s, got %d %+v", tc.authorization, tc.statusCode, tc.code, rr.Code, p)
+		}
+	}
+	authorization = "Bearer s3cret"
+
+	var trash deletedReceiptsResponse
+	json.NewDecoder(send("GET", "/admin/receipts/deleted").Body).Decode(&trash)
+	if len(trash.Receipts) != 1 || trash.Receipts[0].ID != created.ID || trash.Receipts[0].PurgeAt == nil ||
+		!trash.Receipts[0].PurgeAt.Equal(trash.Receipts[0].DeletedAt.Add(time.Hour)) {
+		t.Fatalf("Expected the deleted receipt listed with its purge time, got %+v", trash)
+	}
+
+	rr = send("POST", "/admin/receipts/"+created.ID+"/restore")
+	var restored model.Receipt
+	json.NewDecoder(rr.Body).Decode(&restored)
+	if rr.Code != http.StatusOK || restored.ID != created.ID || restored.Points != 12 {
+		t.Errorf("Expected the receipt restored with its 12 points, got %d %+v", rr.Code, restored)
+	}
+	if rr := send("GET", path+"/points"); rr.Code != http.StatusOK {
+		t.Errorf("Expected a restored receipt's points to be found, got %d", rr.Code)
+	}
+	if rr := send("POST", "/admin/receipts/"+created.ID+"/restore"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected restoring a receipt that is not deleted to be a 404, got %d", rr.Code)
+	}
+
+	// without a SoftDeleteStore a delete is immediate
+	plain := model.NewMemoryStore()
+	plain.Put(model.Receipt{ID: "plain"})
+	rr = httptest.NewRecorder()
+	NewRouter(plain).ServeHTTP(rr, httptest.NewRequest("DELETE", "/receipts/plain", nil))
+	if _, exists := plain.Get("plain"); rr.Code != http.StatusNoContent || exists {
+		t.Errorf("Expected a plain store's receipt removed with a 204, got %d (still stored: %v)", rr.Code, exists)
+	}
+}
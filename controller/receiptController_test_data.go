@@ -195,6 +195,22 @@ func GetReceiptTestData() ReceiptTestData {
                 StatusCode: 200,
                 Response:   `{"id":""}`,
             },
+            {
+                Name: "Valid Receipt With PurchasedAt",
+                Input: `{
+                    "retailer": "Target",
+                    "purchasedAt": "2024-02-07T13:45:00-05:00",
+                    "items": [
+                        {
+                            "shortDescription": "Mountain Dew",
+                            "price": "1.99"
+                        }
+                    ],
+                    "total": "1.99"
+                }`,
+                StatusCode: 200,
+                Response:   `{"id":""}`,
+            },
         },
         Invalid: []ReceiptTestCase{
             {
@@ -212,6 +228,23 @@ func GetReceiptTestData() ReceiptTestData {
                 StatusCode: 400,
                 Response:   "Invalid JSON format",
             },
+            {
+                Name: "PurchasedAt With Split Fields",
+                Input: `{
+                    "retailer": "Target",
+                    "purchasedAt": "2024-02-07T13:45:00-05:00",
+                    "purchaseTime": "13:45",
+                    "items": [
+                        {
+                            "shortDescription": "Mountain Dew",
+                            "price": "1.99"
+                        }
+                    ],
+                    "total": "1.99"
+                }`,
+                StatusCode: 400,
+                Response:   "purchasedAt cannot be combined",
+            },
         },
     }
 }
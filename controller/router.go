@@ -0,0 +1,229 @@
+// controller/router.go
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"receipt-processor-challenge/model"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Route is one endpoint: an HTTP method plus a Go 1.22 ServeMux path
+// pattern (wildcards like {id} are read with r.PathValue).
+type Route struct {
+	Method  string
+	Pattern string
+	Handler http.HandlerFunc
+}
+
+// Routes is the single place endpoints are registered. V1Routes is served both
+// under /v1 and at its original unversioned paths; V2Routes under /v2.
+func (h *Handler) Routes() []Route {
+	routes := []Route{
+		{http.MethodGet, "/openapi.yml", GetOpenAPISpec},
+		{http.MethodGet, "/docs", GetAPIDocs},
+		{http.MethodGet, "/metrics", h.GetMetrics},
+	}
+	if AdminToken != "" {
+		routes = append(routes,
+			Route{http.MethodGet, "/admin/receipts/deleted", requireAdmin(h.ListDeletedReceipts)},
+			Route{http.MethodPost, "/admin/receipts/{id}/restore", requireAdmin(h.RestoreReceipt)})
+	}
+
+	v2 := h.V2Routes()
+	for _, route := range h.V1Routes() {
+		handler := route.Handler
+		if hasRoute(v2, route.Method, route.Pattern) {
+			handler = deprecatedV1(handler)
+		}
+		routes = append(routes,
+			Route{route.Method, route.Pattern, handler},
+			Route{route.Method, "/v1" + route.Pattern, handler})
+	}
+	for _, route := range v2 {
+		routes = append(routes, Route{route.Method, "/v2" + route.Pattern, route.Handler})
+	}
+	return routes
+}
+
+// V1Routes is the original receipt contract (api.yml).
+func (h *Handler) V1Routes() []Route {
+	return []Route{
+		{http.MethodPost, "/receipts/process", h.ProcessReceipt},
+		{http.MethodPost, "/receipts/batch", h.ProcessReceiptBatch},
+		{http.MethodPost, "/receipts/stream", h.ProcessReceiptStream},
+		{http.MethodPost, "/receipts/import", h.ImportReceiptsCSV},
+		{http.MethodGet, "/receipts/export", h.ExportReceipts},
+		{http.MethodGet, "/receipts/{$}", h.ListReceipts},
+		{http.MethodGet, "/receipts/{id}", h.GetReceipt},
+		{http.MethodPut, "/receipts/{id}", h.ReplaceReceipt},
+		{http.MethodPatch, "/receipts/{id}", h.PatchReceipt},
+		{http.MethodDelete, "/receipts/{id}", h.DeleteReceipt},
+		{http.MethodGet, "/receipts/{id}/points", h.GetReceiptPoints},
+		{http.MethodGet, "/receipts/{id}/revisions", h.GetReceiptRevisions},
+	}
+}
+
+// V2Routes adds currency, quantities and member IDs; a v1 route with a v2
+// counterpart is marked deprecated.
+func (h *Handler) V2Routes() []Route {
+	return []Route{
+		{http.MethodPost, "/receipts/process", h.ProcessReceiptV2},
+		{http.MethodGet, "/receipts/{$}", h.ListReceiptsV2},
+		{http.MethodGet, "/receipts/{id}", h.GetReceiptV2},
+		{http.MethodGet, "/receipts/{id}/points", h.GetReceiptPointsV2},
+	}
+}
+
+func hasRoute(routes []Route, method, pattern string) bool {
+	for _, route := range routes {
+		if route.Method == method && route.Pattern == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// V1DeprecatedAt and V1Sunset are announced on every v1 response that has a v2
+// successor; a zero V1Sunset leaves the Sunset header out.
+var (
+	V1DeprecatedAt = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	V1Sunset       = time.Date(2027, time.April, 18, 0, 0, 0, 0, time.UTC)
+)
+
+// deprecatedV1 adds Deprecation (RFC 9745), Sunset (RFC 8594) and a Link to
+// the same path under /v2
+func deprecatedV1(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", V1DeprecatedAt.Unix()))
+		if !V1Sunset.IsZero() {
+			w.Header().Set("Sunset", V1Sunset.UTC().Format(http.TimeFormat))
+		}
+		successor := "/v2" + strings.TrimPrefix(r.URL.Path, "/v1")
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		next(w, r)
+	}
+}
+
+// NewRouter serves the Routes of a Handler over store. A known path requested
+// with the wrong method gets a 405 with an Allow header; anything else falls
+// through to NotFoundHandler.
+func NewRouter(store model.ReceiptStore) http.Handler {
+	return NewHandler(store).Router()
+}
+
+func (h *Handler) Router() http.Handler {
+	return WithRequestID(h.withIdempotency(newMux(h.Routes())))
+}
+
+func newMux(routes []Route) http.Handler {
+	mux := http.NewServeMux()
+	literals := map[string][]string{}
+	for _, route := range routes {
+		// a GET pattern also matches HEAD (net/http drops the body)
+		mux.HandleFunc(route.Method+" "+route.Pattern, route.Handler)
+		if !strings.Contains(route.Pattern, "{") {
+			literals[route.Pattern] = append(literals[route.Pattern], route.Method)
+		}
+	}
+
+	// A literal path like /receipts/process would otherwise hand the methods it
+	// lacks to /receipts/{id}; claim them so the literal path answers with 405.
+	for pattern, methods := range literals {
+		allow := allowHeader(methods)
+		for _, method := range fallbackMethods {
+			if method == http.MethodHead && slices.Contains(methods, http.MethodGet) || slices.Contains(methods, method) {
+				continue
+			}
+			mux.HandleFunc(method+" "+pattern, methodNotAllowed(allow))
+		}
+	}
+	return problemMux{mux}
+}
+
+// fallbackMethods are the methods a literal path answers itself when it has
+// no route for them
+var fallbackMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// allowHeader lists methods for an Allow header: sorted, with HEAD implied by
+// GET and OPTIONS always present
+func allowHeader(methods []string) string {
+	seen := map[string]bool{http.MethodOptions: true}
+	for _, method := range methods {
+		seen[method] = true
+		if method == http.MethodGet {
+			seen[http.MethodHead] = true
+		}
+	}
+	allowed := make([]string, 0, len(seen))
+	for method := range seen {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+func methodNotAllowed(allow string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+			fmt.Sprintf("%s is not supported for %s; allowed methods: %s", r.Method, r.URL.Path, allow))
+	}
+}
+
+// problemMux answers the requests ServeMux cannot route with problem+json: its
+// 405, keeping the Allow header it works out, and its 404. OPTIONS on a known
+// path is a 204 with that Allow header.
+type problemMux struct {
+	*http.ServeMux
+}
+
+func (m problemMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, pattern := m.Handler(r)
+	if pattern != "" {
+		m.ServeMux.ServeHTTP(w, r)
+		return
+	}
+
+	unrouted := &headerRecorder{header: http.Header{}}
+	handler.ServeHTTP(unrouted, r)
+	switch unrouted.status {
+	case http.StatusMethodNotAllowed:
+		methodNotAllowed(allowHeader(strings.Split(unrouted.header.Get("Allow"), ", ")))(w, r)
+	case http.StatusNotFound:
+		NotFoundHandler(w, r)
+	default:
+		// e.g. a redirect to the clean path
+		m.ServeMux.ServeHTTP(w, r)
+	}
+}
+
+// headerRecorder keeps the status and headers of ServeMux's own error
+// responses, discarding their plain-text bodies
+type headerRecorder struct {
+	header http.Header
+	status int
+}
+
+func (h *headerRecorder) Header() http.Header { return h.header }
+
+func (h *headerRecorder) WriteHeader(status int) {
+	if h.status == 0 {
+		h.status = status
+	}
+}
+
+func (h *headerRecorder) Write(data []byte) (int, error) {
+	h.WriteHeader(http.StatusOK)
+	return len(data), nil
+}
@@ -0,0 +1,146 @@
+// controller/streamController.go
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"receipt-processor-challenge/model"
+)
+
+// MaxStreamLineSize bounds memory per NDJSON line; longer lines are rejected.
+var MaxStreamLineSize = 1 << 20
+
+// exportFlushEvery is how many receipts are written between flushes.
+const exportFlushEvery = 100
+
+// streamResult is a receiptResult keyed by its 1-based input line.
+type streamResult struct {
+	Line int `json:"line"`
+	receiptResult
+}
+
+// ProcessReceiptStream ingests newline-delimited JSON receipts (POST /receipts/stream)
+// and writes one NDJSON result per non-blank input line as it goes.
+// Only one line is held in memory at a time.
+func (h *Handler) ProcessReceiptStream(w http.ResponseWriter, r *http.Request) {
+	profile, err := validationProfile(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	// results are written while the body is still being read
+	controller := http.NewResponseController(w)
+	controller.EnableFullDuplex()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+
+	reader := bufio.NewReader(r.Body)
+	for lineNumber := 1; ; lineNumber++ {
+		line, readErr := readLine(reader, MaxStreamLineSize)
+
+		var result receiptResult
+		switch {
+		case errors.Is(readErr, errLineTooLong):
+			result = receiptResult{Status: http.StatusRequestEntityTooLarge, Code: CodeLineTooLong,
+				Detail: fmt.Sprintf("line is longer than %d bytes", MaxStreamLineSize)}
+		case readErr != nil && !errors.Is(readErr, io.EOF):
+			// the connection broke; nothing more can be read
+			encoder.Encode(streamResult{Line: lineNumber, receiptResult: receiptResult{
+				Status: http.StatusBadRequest, Code: CodeMalformedJSON, Detail: "error reading request body"}})
+			return
+		case len(bytes.TrimSpace(line)) == 0:
+			if errors.Is(readErr, io.EOF) {
+				return
+			}
+			continue
+		default:
+			var receipt model.Receipt
+			receipt, result = processReceiptJSON(line, profile)
+			if result.ID != "" {
+				if err := h.putReceipt(&receipt); err != nil {
+					result = problemResult(storeProblem(err))
+				} else {
+					result.Warnings = receipt.Warnings
+				}
+			}
+		}
+
+		encoder.Encode(streamResult{Line: lineNumber, receiptResult: result})
+		controller.Flush()
+		if errors.Is(readErr, io.EOF) {
+			return
+		}
+	}
+}
+
+// ExportReceipts streams every stored receipt as NDJSON (GET /receipts/export)
+// without building the whole list in memory; ?format=csv gives one row per item.
+func (h *Handler) ExportReceipts(w http.ResponseWriter, r *http.Request) {
+	// validate ?view before the 200 is sent
+	if err := applyReceiptView(r, &model.Receipt{}); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "ndjson":
+	case "csv":
+		exportReceiptsCSV(w, h.Store)
+		return
+	default:
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("unknown format %q (expected ndjson or csv)", format))
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+
+	written := 0
+	h.Store.Iterate(func(receipt model.Receipt) bool {
+		applyReceiptView(r, &receipt)
+		if err := encoder.Encode(receipt); err != nil {
+			// client went away
+			return false
+		}
+		if written++; written%exportFlushEvery == 0 {
+			controller.Flush()
+		}
+		return true
+	})
+	controller.Flush()
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readLine returns the next line without its newline. A line over max bytes is
+// skipped up to its newline and reported as errLineTooLong.
+func readLine(reader *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > max+1 {
+			// drain the rest of the oversized line
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = reader.ReadSlice('\n')
+			}
+			if err == nil || errors.Is(err, io.EOF) {
+				return nil, errLineTooLong
+			}
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		return bytes.TrimRight(line, "\r\n"), err
+	}
+}
@@ -0,0 +1,244 @@
+// controller/updateController.go
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"receipt-processor-challenge/model"
+	"strings"
+	"time"
+)
+
+// MergePatchContentType is the PATCH body (RFC 7396); application/json is accepted too.
+const MergePatchContentType = "application/merge-patch+json"
+
+// readOnlyFields are computed by the server; a patch that sets one is refused
+var readOnlyFields = []string{"id", "points", "purchaseOffset", "raw", "warnings"}
+
+// ReplaceReceipt replaces a stored receipt with a full JSON or XML body, as
+// for POST /receipts/process (PUT /receipts/{id})
+func (h *Handler) ReplaceReceipt(w http.ResponseWriter, r *http.Request) {
+	h.updateReceipt(w, r, func(stored model.Receipt) (model.Receipt, *problem) {
+		decode := decodeReceipt
+		if isXMLRequest(r) {
+			decode = decodeReceiptXML
+		}
+		receipt, err := decode(r.Body)
+		if err != nil {
+			p := decodeProblem(err)
+			return receipt, &p
+		}
+		return receipt, nil
+	})
+}
+
+// PatchReceipt applies a JSON Merge Patch to a stored receipt's submitted
+// fields (PATCH /receipts/{id}). Setting purchasedAt replaces purchaseDate and
+// purchaseTime, and the other way round; null removes a field.
+func (h *Handler) PatchReceipt(w http.ResponseWriter, r *http.Request) {
+	h.updateReceipt(w, r, func(stored model.Receipt) (model.Receipt, *problem) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "" && mediaType != MergePatchContentType && mediaType != "application/json" {
+			return model.Receipt{}, &problem{Status: http.StatusUnsupportedMediaType, Code: CodeUnsupportedMediaType,
+				Detail: fmt.Sprintf("a patch must be %s, not %s", MergePatchContentType, mediaType)}
+		}
+
+		var patch map[string]any
+		decoder := json.NewDecoder(limitBody(r.Body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&patch); err != nil {
+			p := decodeProblem(err)
+			var notObject *json.UnmarshalTypeError
+			if errors.As(err, &notObject) {
+				p.Code, p.Detail, p.Errors = CodeMalformedJSON, "a merge patch must be a JSON object", nil
+			}
+			return model.Receipt{}, &p
+		}
+		var errs model.ValidationErrors
+		for _, field := range readOnlyFields {
+			if _, set := patch[field]; set {
+				errs = append(errs, model.ValidationError{Pointer: "/" + field, Code: model.CodeNotAllowed,
+					Message: field + " is computed by the server and cannot be changed"})
+			}
+		}
+		if len(errs) > 0 {
+			p := ingestProblem(errs)
+			return model.Receipt{}, &p
+		}
+
+		merged, _ := json.Marshal(mergePatch(patchTarget(stored, patch), patch))
+		receipt, err := decodeReceipt(bytes.NewReader(merged))
+		if err != nil {
+			p := decodeProblem(err)
+			return receipt, &p
+		}
+		if _, replaced := patch["items"]; !replaced {
+			// v2 quantities are not in the v1 shape the patch was applied to
+			for i := range receipt.Items {
+				receipt.Items[i].Quantity = stored.Items[i].Quantity
+			}
+		}
+		return receipt, nil
+	})
+}
+
+// GetReceiptRevisions lists the versions edits replaced, oldest first
+// (GET /receipts/{id}/revisions)
+func (h *Handler) GetReceiptRevisions(w http.ResponseWriter, r *http.Request) {
+	e, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	receipt, exists := h.Store.Get(r.PathValue("id"))
+	if !exists {
+		writeError(w, r, http.StatusNotFound, CodeReceiptNotFound, "Receipt not found")
+		return
+	}
+
+	response := revisionsResponse{
+		Revision:  receipt.CurrentRevision(),
+		Revisions: append([]model.Revision{}, receipt.History...),
+	}
+	for i := range response.Revisions {
+		if err := applyReceiptView(r, &response.Revisions[i].Receipt); err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+			return
+		}
+	}
+	w.Header().Set("ETag", receipt.ETag())
+	writeNegotiated(w, r, e, "revisions", response)
+}
+
+type revisionsResponse struct {
+	Revision  uint             `json:"revision" xml:"current,attr"`
+	Revisions []model.Revision `json:"revisions" xml:"revision"`
+}
+
+// updateReceipt is the edit shared by PUT and PATCH: check If-Match against
+// the stored revision, build the new contents, validate, normalize and score
+// them, and store them as the next revision. The revision is checked again
+// under h.edits before the Put, so of two concurrent edits only one wins.
+func (h *Handler) updateReceipt(w http.ResponseWriter, r *http.Request, build func(stored model.Receipt) (model.Receipt, *problem)) {
+	e, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	profile, err := validationProfile(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	stored, exists := h.Store.Get(r.PathValue("id"))
+	if !exists {
+		writeError(w, r, http.StatusNotFound, CodeReceiptNotFound, "Receipt not found")
+		return
+	}
+	if p := checkIfMatch(w, r, stored); p != nil {
+		writeProblem(w, r, *p)
+		return
+	}
+
+	receipt, p := build(stored)
+	if p != nil {
+		writeProblem(w, r, *p)
+		return
+	}
+	// v2-only fields are not in the v1 body, so an edit keeps them
+	receipt.Currency, receipt.MemberID = stored.Currency, stored.MemberID
+	if err := scoreReceipt(&receipt, profile); err != nil {
+		writeProblem(w, r, ingestProblem(err))
+		return
+	}
+
+	h.edits.Lock()
+	current, exists := h.Store.Get(stored.ID)
+	if !exists {
+		h.edits.Unlock()
+		writeError(w, r, http.StatusNotFound, CodeReceiptNotFound, "Receipt not found")
+		return
+	}
+	if current.CurrentRevision() != stored.CurrentRevision() {
+		h.edits.Unlock()
+		w.Header().Set("ETag", current.ETag())
+		writeError(w, r, http.StatusPreconditionFailed, CodePreconditionFailed, "the receipt was changed by another request; fetch it again and retry")
+		return
+	}
+	receipt = stored.Revise(receipt, time.Now())
+	err = h.putReceipt(&receipt)
+	h.edits.Unlock()
+	if err != nil {
+		writeProblem(w, r, storeProblem(err))
+		return
+	}
+
+	w.Header().Set("ETag", receipt.ETag())
+	if err := applyReceiptView(r, &receipt); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	writeNegotiated(w, r, e, "receipt", receipt)
+}
+
+// checkIfMatch requires an If-Match naming the stored revision's ETag (or *),
+// so an edit based on a stale copy cannot undo another one
+func checkIfMatch(w http.ResponseWriter, r *http.Request, stored model.Receipt) *problem {
+	etag := stored.ETag()
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		return &problem{Status: http.StatusPreconditionRequired, Code: CodePreconditionRequired,
+			Detail: "send If-Match with the receipt's ETag (from GET /receipts/{id}) to change it"}
+	}
+	for _, tag := range strings.Split(strings.Join(values, ","), ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == etag {
+			return nil
+		}
+	}
+	w.Header().Set("ETag", etag)
+	return &problem{Status: http.StatusPreconditionFailed, Code: CodePreconditionFailed,
+		Detail: fmt.Sprintf("If-Match does not match the receipt's current ETag %s", etag)}
+}
+
+// patchTarget is the stored receipt as a submission the patch applies to,
+// holding whichever purchase time form the patch does not replace
+func patchTarget(stored model.Receipt, patch map[string]any) map[string]any {
+	items := make([]any, len(stored.Items))
+	for i, item := range stored.Items {
+		items[i] = map[string]any{"shortDescription": item.ShortDescription, "price": item.Price}
+	}
+	target := map[string]any{"retailer": stored.Retailer, "items": items, "total": stored.Total}
+
+	setsPurchasedAt := patch["purchasedAt"] != nil
+	setsDateTime := patch["purchaseDate"] != nil || patch["purchaseTime"] != nil
+	switch {
+	case stored.PurchasedAt != "" && !setsDateTime:
+		target["purchasedAt"] = stored.PurchasedAt
+	case !setsPurchasedAt:
+		target["purchaseDate"], target["purchaseTime"] = stored.PurchaseDate, stored.PurchaseTime
+	}
+	return target
+}
+
+// mergePatch applies an RFC 7396 merge patch to target
+func mergePatch(target, patch any) any {
+	fields, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	merged, ok := target.(map[string]any)
+	if !ok {
+		merged = map[string]any{}
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = mergePatch(merged[name], value)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,132 @@
+// controller/v2Controller.go
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"receipt-processor-challenge/model"
+)
+
+// ProcessReceiptV2 accepts a v2 receipt (POST /v2/receipts/process) and answers
+// with its ID and points.
+func (h *Handler) ProcessReceiptV2(w http.ResponseWriter, r *http.Request) {
+	e, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	profile, err := validationProfile(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	request, err := decodeReceiptV2(r)
+	if err != nil {
+		writeDecodeProblem(w, r, err)
+		return
+	}
+
+	receipt, err := ingestReceiptV2(request, profile)
+	if err != nil {
+		writeProblem(w, r, ingestProblem(err))
+		return
+	}
+	if err := h.putReceipt(&receipt); err != nil {
+		writeProblem(w, r, storeProblem(err))
+		return
+	}
+
+	writeNegotiated(w, r, e, "receipt", processResponseV2{
+		ID:       receipt.ID,
+		Points:   receipt.Points,
+		Warnings: warningsToV2(receipt.Warnings),
+	})
+}
+
+// GetReceiptV2 returns a receipt in the v2 shape (GET /v2/receipts/{id})
+func (h *Handler) GetReceiptV2(w http.ResponseWriter, r *http.Request) {
+	e, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	receipt, exists := h.Store.Get(r.PathValue("id"))
+	if !exists {
+		writeError(w, r, http.StatusNotFound, CodeReceiptNotFound, "Receipt not found")
+		return
+	}
+	w.Header().Set("ETag", receipt.ETag())
+	writeNegotiated(w, r, e, "receipt", receiptToV2(receipt))
+}
+
+// GetReceiptPointsV2 returns the points with the rule-by-rule breakdown
+// (GET /v2/receipts/{id}/points)
+func (h *Handler) GetReceiptPointsV2(w http.ResponseWriter, r *http.Request) {
+	e, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	receipt, exists := h.Store.Get(r.PathValue("id"))
+	if !exists {
+		writeError(w, r, http.StatusNotFound, CodeReceiptNotFound, "Receipt not found")
+		return
+	}
+	writeNegotiated(w, r, e, "points", pointsResponseV2{
+		Points:    receipt.Points,
+		Breakdown: receipt.Breakdown,
+	})
+}
+
+// ListReceiptsV2 is ListReceipts with v2 receipts (GET /v2/receipts/)
+func (h *Handler) ListReceiptsV2(w http.ResponseWriter, r *http.Request) {
+	e, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	query, err := parseReceiptQuery(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	page, err := model.QueryReceipts(h.Store, query)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	v2 := receiptPageV2{Receipts: make([]receiptV2, 0, len(page.Receipts)), Next: page.Next}
+	for _, receipt := range page.Receipts {
+		v2.Receipts = append(v2.Receipts, receiptToV2(receipt))
+	}
+	writeNegotiated(w, r, e, "receipts", v2)
+}
+
+// decodeReceiptV2 reads a JSON (or, with an XML Content-Type, XML) v2 body
+func decodeReceiptV2(r *http.Request) (receiptRequestV2, error) {
+	var request receiptRequestV2
+	request.Items = []itemRequestV2{}
+	if isXMLRequest(r) {
+		return request, decodeStrictXML(r.Body, "receipt", &request)
+	}
+	return request, decodeStrictJSON(r.Body, &request)
+}
+
+// ingestReceiptV2 converts the request and runs it through ingestReceipt, with
+// every error reported against the v2 field names
+func ingestReceiptV2(request receiptRequestV2, profile model.ValidationProfile) (model.Receipt, error) {
+	receipt, errs := request.toModel(profile)
+	if len(errs) > 0 {
+		// report the model's findings too, so the client sees everything at once
+		var modelErrs model.ValidationErrors
+		errors.As(receipt.ValidateReceiptWithProfile(profile), &modelErrs)
+		return receipt, validationErrorsToV2(modelErrs, errs)
+	}
+
+	if err := ingestReceipt(&receipt, profile); err != nil {
+		var modelErrs model.ValidationErrors
+		if errors.As(err, &modelErrs) {
+			return receipt, validationErrorsToV2(modelErrs, nil)
+		}
+		return receipt, err
+	}
+	return receipt, nil
+}
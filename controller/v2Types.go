@@ -0,0 +1,222 @@
+// controller/v2Types.go
+package controller
+
+import (
+	"fmt"
+	"receipt-processor-challenge/model"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is assumed for v2 requests without a currency and for
+// receipts submitted through v1.
+const DefaultCurrency = "USD"
+
+var (
+	currencyRegex = regexp.MustCompile(`^[A-Z]{3}$`)
+	memberIDRegex = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+	// amounts the v2 converter can multiply out; anything else is left for model validation
+	centsRegex       = regexp.MustCompile(`^(\d+)(?:\.(\d{1,2}))?$`)
+	strictCentsRegex = regexp.MustCompile(`^\d+\.\d{2}$`)
+)
+
+// receiptRequestV2 is the v2 POST body. Items carry a quantity and unit price;
+// the line total is computed, so v1 scoring and the total check apply unchanged.
+type receiptRequestV2 struct {
+	Retailer     string          `json:"retailer" xml:"retailer"`
+	PurchaseDate string          `json:"purchaseDate,omitempty" xml:"purchaseDate,omitempty"`
+	PurchaseTime string          `json:"purchaseTime,omitempty" xml:"purchaseTime,omitempty"`
+	PurchasedAt  string          `json:"purchasedAt,omitempty" xml:"purchasedAt,omitempty"`
+	Currency     string          `json:"currency,omitempty" xml:"currency,omitempty"`
+	MemberID     string          `json:"memberId,omitempty" xml:"memberId,omitempty"`
+	Items        []itemRequestV2 `json:"items" xml:"items>item"`
+	Total        string          `json:"total" xml:"total"`
+}
+
+type itemRequestV2 struct {
+	ShortDescription string `json:"shortDescription" xml:"shortDescription"`
+	// Quantity defaults to 1
+	Quantity  *int   `json:"quantity,omitempty" xml:"quantity,omitempty"`
+	UnitPrice string `json:"unitPrice" xml:"unitPrice"`
+}
+
+// receiptV2 is a stored receipt in the v2 shape
+type receiptV2 struct {
+	ID             string          `json:"id" xml:"id"`
+	Retailer       string          `json:"retailer" xml:"retailer"`
+	PurchaseDate   string          `json:"purchaseDate" xml:"purchaseDate"`
+	PurchaseTime   string          `json:"purchaseTime" xml:"purchaseTime"`
+	PurchaseOffset string          `json:"purchaseOffset,omitempty" xml:"purchaseOffset,omitempty"`
+	Currency       string          `json:"currency" xml:"currency"`
+	MemberID       string          `json:"memberId,omitempty" xml:"memberId,omitempty"`
+	Items          []itemV2        `json:"items" xml:"items>item"`
+	Total          string          `json:"total" xml:"total"`
+	Points         uint            `json:"points" xml:"points"`
+	Warnings       []model.Warning `json:"warnings,omitempty" xml:"warnings>warning,omitempty"`
+}
+
+type itemV2 struct {
+	ShortDescription string `json:"shortDescription" xml:"shortDescription"`
+	Quantity         uint   `json:"quantity" xml:"quantity"`
+	UnitPrice        string `json:"unitPrice" xml:"unitPrice"`
+	Price            string `json:"price" xml:"price"`
+}
+
+type processResponseV2 struct {
+	ID       string          `json:"id" xml:"id"`
+	Points   uint            `json:"points" xml:"points"`
+	Warnings []model.Warning `json:"warnings,omitempty" xml:"warnings>warning,omitempty"`
+}
+
+type pointsResponseV2 struct {
+	Points    uint                  `json:"points" xml:"points"`
+	Breakdown model.PointsBreakdown `json:"breakdown" xml:"breakdown"`
+}
+
+type receiptPageV2 struct {
+	Receipts []receiptV2 `json:"receipts" xml:"receipt"`
+	Next     string      `json:"next,omitempty" xml:"next,attr,omitempty"`
+}
+
+// toModel converts a v2 request into the shared model. Errors that only exist in
+// v2 (currency, memberId, quantity, unitPrice) are returned with v2 pointers;
+// everything else is left for the model's own validation.
+func (request receiptRequestV2) toModel(profile model.ValidationProfile) (model.Receipt, model.ValidationErrors) {
+	var errs model.ValidationErrors
+	receipt := model.Receipt{
+		Retailer:     request.Retailer,
+		PurchaseDate: request.PurchaseDate,
+		PurchaseTime: request.PurchaseTime,
+		PurchasedAt:  request.PurchasedAt,
+		Total:        request.Total,
+		Currency:     request.Currency,
+		MemberID:     request.MemberID,
+		Items:        make([]model.Item, 0, len(request.Items)),
+	}
+
+	if receipt.Currency == "" {
+		receipt.Currency = DefaultCurrency
+	} else if !currencyRegex.MatchString(receipt.Currency) {
+		errs = append(errs, model.ValidationError{Pointer: "/currency", Code: model.CodePatternMismatch,
+			Message: "currency must be a three-letter ISO 4217 code such as USD"})
+	}
+	if receipt.MemberID != "" && !memberIDRegex.MatchString(receipt.MemberID) {
+		errs = append(errs, model.ValidationError{Pointer: "/memberId", Code: model.CodePatternMismatch,
+			Message: "memberId must be 1-64 letters, digits, '-' or '_'"})
+	}
+
+	for i, item := range request.Items {
+		quantity := 1
+		if item.Quantity != nil {
+			quantity = *item.Quantity
+		}
+		// by default the unit price goes to the model as-is, so it is validated like a v1 price
+		converted := model.Item{ShortDescription: item.ShortDescription, Price: item.UnitPrice, Quantity: 1}
+
+		switch cents, ok := parseCents(item.UnitPrice); {
+		case quantity < 1:
+			errs = append(errs, model.ValidationError{Pointer: fmt.Sprintf("/items/%d/quantity", i), Code: model.CodeOutOfRange,
+				Message: "item quantity must be at least 1"})
+		case ok && profile == model.ProfileStrict && !strictCentsRegex.MatchString(item.UnitPrice):
+			errs = append(errs, model.ValidationError{Pointer: fmt.Sprintf("/items/%d/unitPrice", i), Code: model.CodePatternMismatch,
+				Message: fmt.Sprintf("item unitPrice %q must have exactly two decimal places", item.UnitPrice)})
+		case ok && quantity > 1:
+			converted.Price, converted.Quantity = formatCents(cents*int64(quantity)), uint(quantity)
+		}
+		receipt.Items = append(receipt.Items, converted)
+	}
+	return receipt, errs
+}
+
+// receiptToV2 presents any stored receipt, v1 or v2, in the v2 shape
+func receiptToV2(receipt model.Receipt) receiptV2 {
+	v2 := receiptV2{
+		ID:             receipt.ID,
+		Retailer:       receipt.Retailer,
+		PurchaseDate:   receipt.PurchaseDate,
+		PurchaseTime:   receipt.PurchaseTime,
+		PurchaseOffset: receipt.PurchaseOffset,
+		Currency:       receipt.Currency,
+		MemberID:       receipt.MemberID,
+		Items:          make([]itemV2, 0, len(receipt.Items)),
+		Total:          receipt.Total,
+		Points:         receipt.Points,
+		Warnings:       warningsToV2(receipt.Warnings),
+	}
+	if v2.Currency == "" {
+		v2.Currency = DefaultCurrency
+	}
+	for _, item := range receipt.Items {
+		quantity := item.Quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+		unitPrice := item.Price
+		if cents, ok := parseCents(item.Price); ok && quantity > 1 {
+			unitPrice = formatCents(cents / int64(quantity))
+		}
+		v2.Items = append(v2.Items, itemV2{
+			ShortDescription: item.ShortDescription,
+			Quantity:         quantity,
+			UnitPrice:        unitPrice,
+			Price:            item.Price,
+		})
+	}
+	return v2
+}
+
+// validationErrorsToV2 renames model pointers that differ in v2 (/items/N/price
+// is /items/N/unitPrice), skipping pointers v2 already reported
+func validationErrorsToV2(errs model.ValidationErrors, reported model.ValidationErrors) model.ValidationErrors {
+	seen := map[string]bool{}
+	for _, e := range reported {
+		seen[e.Pointer] = true
+	}
+	for _, e := range errs {
+		e.Pointer = pointerToV2(e.Pointer)
+		if !seen[e.Pointer] {
+			seen[e.Pointer] = true
+			reported = append(reported, e)
+		}
+	}
+	return reported
+}
+
+func warningsToV2(warnings []model.Warning) []model.Warning {
+	converted := make([]model.Warning, 0, len(warnings))
+	for _, warning := range warnings {
+		warning.Pointer = pointerToV2(warning.Pointer)
+		converted = append(converted, warning)
+	}
+	if len(converted) == 0 {
+		return nil
+	}
+	return converted
+}
+
+func pointerToV2(pointer string) string {
+	if strings.HasPrefix(pointer, "/items/") && strings.HasSuffix(pointer, "/price") {
+		return strings.TrimSuffix(pointer, "/price") + "/unitPrice"
+	}
+	return pointer
+}
+
+// parseCents reads a plain decimal amount ("12", "1.5", "1.50") as whole cents
+func parseCents(amount string) (int64, bool) {
+	match := centsRegex.FindStringSubmatch(amount)
+	if match == nil {
+		return 0, false
+	}
+	whole, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil || whole > 1<<40 {
+		return 0, false
+	}
+	fraction := match[2] + strings.Repeat("0", 2-len(match[2]))
+	cents, _ := strconv.ParseInt(fraction, 10, 64)
+	return whole*100 + cents, true
+}
+
+func formatCents(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
@@ -2,4 +2,7 @@ module receipt-processor-challenge
 
 go 1.22.4
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/google/uuid v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
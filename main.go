@@ -1,18 +1,164 @@
 package main
 
 import (
+	"context"
+	_ "embed"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"receipt-processor-challenge/config"
 	"receipt-processor-challenge/controller"
+	"receipt-processor-challenge/model"
+	"receipt-processor-challenge/openapi"
+	"syscall"
+	"time"
 )
 
+//go:embed api.yml
+var apiSpec []byte
+
 func main() {
-	http.HandleFunc("/receipts/process", controller.ProcessReceipt)
-	http.HandleFunc("/receipts/", controller.GetReceipt)
-	
-	// Handle all other routes
-    http.HandleFunc("/", controller.NotFoundHandler)
+	// Purchase date plausibility policy (0 disables a bound)
+	maxFuture := flag.Duration("max-future", config.ActiveDatePolicy.MaxFuture, "reject purchases more than this far in the future")
+	maxAge := flag.Duration("max-age", config.ActiveDatePolicy.MaxAge, "reject receipts older than this submission window (e.g. 720h)")
+	calendarPath := flag.String("calendar", "", "JSON calendar file with weekend/holiday/day-range point rules")
+	v1Sunset := flag.String("v1-sunset", controller.V1Sunset.Format("2006-01-02"), "date (YYYY-MM-DD) announced in the Sunset header of deprecated v1 responses; empty to omit")
+	dev := flag.Bool("dev", false, "log requests and responses that do not match api.yml")
+	validation := flag.String("validation", string(model.DefaultValidationProfile), "default validation profile: lenient or strict (api.yml patterns)")
+	duplicates := flag.String("duplicates", string(model.ActiveDuplicatePolicy), "what to do with a receipt already submitted (same content, purchase time within a minute): reject, flag or accept")
+	idempotencyWindow := flag.Duration("idempotency-window", controller.IdempotencyWindow, "how long a POST response is kept to replay for a repeated Idempotency-Key")
+	idempotencyMaxKeys := flag.Int("idempotency-max-keys", controller.MaxIdempotencyKeys, "forget the oldest Idempotency-Key responses beyond this many (0 no limit)")
+	idempotencyMaxBytes := flag.Int64("idempotency-max-bytes", controller.MaxIdempotencyBytes, "forget the oldest Idempotency-Key responses once they hold this many bytes (0 no limit)")
+	idempotencyMaxResponse := flag.Int64("idempotency-max-response", controller.MaxIdempotentResponseSize, "do not keep an Idempotency-Key response longer than this many bytes")
+	storeKind := flag.String("store", "memory", "receipt store: memory (lost on restart) or file (write-ahead log in -data-dir)")
+	dataDir := flag.String("data-dir", "data", "directory for the file store's log and snapshots")
+	fsync := flag.String("fsync", string(model.SyncAlways), "when the file store fsyncs its log: always, interval or never")
+	fsyncInterval := flag.Duration("fsync-interval", time.Second, "how often -fsync interval syncs the log")
+	maxReceipts := flag.Int("max-receipts", 0, "evict receipts beyond this many (0 no limit)")
+	maxBytes := flag.Int64("max-bytes", 0, "evict receipts once their estimated memory exceeds this many bytes (0 no limit)")
+	ttl := flag.Duration("ttl", 0, "forget receipts this long after they were stored, e.g. 720h (0 never)")
+	eviction := flag.String("eviction", string(model.EvictLRU), "which receipt goes first at a limit: lru or oldest")
+	snapshotEvery := flag.Int("snapshot-every", 1000, "compact the file store's log into a snapshot after this many writes (0 never)")
+	adminToken := flag.String("admin-token", os.Getenv("RECEIPTS_ADMIN_TOKEN"), "bearer token for the /admin routes, which are off without one (default $RECEIPTS_ADMIN_TOKEN)")
+	maxRevisions := flag.Int("max-revisions", model.MaxRevisions, "how many replaced versions of an edited receipt to keep (0 all)")
+	purgeAfter := flag.Duration("purge-after", 30*24*time.Hour, "remove deleted receipts for good this long after deletion; until then an admin can restore them (0 never)")
+	flag.Parse()
+
+	profile, err := model.ParseValidationProfile(*validation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	model.DefaultValidationProfile = profile
+
+	if model.ActiveDuplicatePolicy, err = model.ParseDuplicatePolicy(*duplicates); err != nil {
+		log.Fatalf("-duplicates: %v", err)
+	}
+
+	model.MaxRevisions = *maxRevisions
+
+	config.ActiveDatePolicy.MaxFuture = *maxFuture
+	config.ActiveDatePolicy.MaxAge = *maxAge
+
+	if *calendarPath != "" {
+		calendar, err := config.LoadCalendar(*calendarPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.ActiveCalendar = calendar
+	}
+
+	controller.V1Sunset = time.Time{}
+	if *v1Sunset != "" {
+		if controller.V1Sunset, err = time.Parse("2006-01-02", *v1Sunset); err != nil {
+			log.Fatalf("-v1-sunset: %v", err)
+		}
+	}
+
+	controller.IdempotencyWindow = *idempotencyWindow
+	controller.MaxIdempotencyKeys = *idempotencyMaxKeys
+	controller.MaxIdempotencyBytes = *idempotencyMaxBytes
+	controller.MaxIdempotentResponseSize = *idempotencyMaxResponse
+	controller.AdminToken = *adminToken
+
+	spec, err := openapi.Parse(apiSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	controller.APISpec = spec
+
+	var store model.ReceiptStore = model.NewShardedStore(0)
+	switch *storeKind {
+	case "memory":
+	case "file":
+		syncMode, err := model.ParseSyncMode(*fsync)
+		if err != nil {
+			log.Fatalf("-fsync: %v", err)
+		}
+		fileStore, err := model.OpenFileStore(*dataDir, model.FileStoreOptions{
+			Sync:          syncMode,
+			SyncInterval:  *fsyncInterval,
+			SnapshotEvery: *snapshotEvery,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer fileStore.Close()
+		stats := fileStore.Recovery()
+		log.Printf("Recovered %d receipts from %s (%d from the snapshot, %d log records replayed, %d damaged bytes truncated)",
+			len(fileStore.List()), *dataDir, stats.SnapshotReceipts, stats.ReplayedRecords, stats.TruncatedBytes)
+		store = fileStore
+	default:
+		log.Fatalf("-store: unknown store %q (expected memory or file)", *storeKind)
+	}
+
+	// indexes sit under the limits, so evictions keep them up to date
+	store = model.NewIndexedStore(store)
+	if *maxReceipts > 0 || *maxBytes > 0 || *ttl > 0 {
+		policy, err := model.ParseEvictionPolicy(*eviction)
+		if err != nil {
+			log.Fatalf("-eviction: %v", err)
+		}
+		bounded := model.NewBoundedStore(store, model.BoundedStoreOptions{
+			MaxReceipts: *maxReceipts,
+			MaxBytes:    *maxBytes,
+			TTL:         *ttl,
+			Policy:      policy,
+		})
+		defer bounded.Close()
+		store = bounded
+	}
+	// outermost, so a soft delete is an ordinary Put to every store beneath it
+	trash := model.NewSoftDeleteStore(store, model.SoftDeleteStoreOptions{PurgeAfter: *purgeAfter})
+	defer trash.Close()
+	store = trash
+
+	// every endpoint is registered in controller.Routes
+	handler := controller.NewRouter(store)
+	if *dev {
+		handler = spec.Middleware(handler, log.Printf)
+	}
+	server := &http.Server{Addr: ":8080", Handler: handler}
+
+	// on SIGINT/SIGTERM, finish in-flight requests so the deferred store Close runs
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
 	fmt.Println("Server is running on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	// ListenAndServe returns as soon as Shutdown starts; wait for it to drain
+	<-drained
 }
@@ -0,0 +1,393 @@
+// model/boundedStore.go
+package model
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EvictionPolicy picks which receipt goes first when a limit is reached.
+type EvictionPolicy string
+
+const (
+	// EvictLRU removes the receipt read or written least recently.
+	EvictLRU EvictionPolicy = "lru"
+	// EvictOldest removes the receipt stored first, however often it is read.
+	EvictOldest EvictionPolicy = "oldest"
+)
+
+func ParseEvictionPolicy(policy string) (EvictionPolicy, error) {
+	switch EvictionPolicy(policy) {
+	case EvictLRU, EvictOldest:
+		return EvictionPolicy(policy), nil
+	}
+	return "", fmt.Errorf("unknown eviction policy %q (expected %s or %s)", policy, EvictLRU, EvictOldest)
+}
+
+// BoundedStoreOptions are the limits; a zero limit is not enforced.
+type BoundedStoreOptions struct {
+	MaxReceipts int
+	// MaxBytes is a budget for the estimated in-memory size of the receipts
+	MaxBytes int64
+	// TTL is how long a receipt lives after it was last written
+	TTL    time.Duration
+	Policy EvictionPolicy
+	// SweepInterval is how often expired receipts are removed in the background
+	// (default TTL/10, at least a second); until then they are only hidden
+	SweepInterval time.Duration
+}
+
+// StoreStats are counters a store can expose as metrics.
+type StoreStats struct {
+	Receipts int
+	Bytes    int64
+	// Evicted counts removals by reason: EvictedMaxReceipts, EvictedMaxBytes or EvictedTTL
+	Evicted map[string]uint64
+}
+
+const (
+	EvictedMaxReceipts = "max_receipts"
+	EvictedMaxBytes    = "max_bytes"
+	EvictedTTL         = "ttl"
+)
+
+var ErrReceiptTooLarge = errors.New("receipt is larger than the store's memory budget")
+
+type boundedEntry struct {
+	id       string
+	size     int64
+	storedAt time.Time
+}
+
+// BoundedStore wraps another store and keeps it within a receipt count and
+// memory budget, removing receipts by its EvictionPolicy, and expires receipts
+// after a TTL. Expired receipts are not found, listed or iterated.
+type BoundedStore struct {
+	inner   ReceiptStore
+	options BoundedStoreOptions
+	now     func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List // front is the most recently used (LRU) or stored (oldest-first)
+	entries map[string]*list.Element
+	bytes   int64
+	evicted map[string]uint64
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewBoundedStore tracks the receipts already in inner as stored now, evicting
+// at once if they are over the limits.
+func NewBoundedStore(inner ReceiptStore, options BoundedStoreOptions) *BoundedStore {
+	if options.Policy == "" {
+		options.Policy = EvictLRU
+	}
+	s := &BoundedStore{
+		inner:   inner,
+		options: options,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		evicted: make(map[string]uint64),
+	}
+
+	s.mu.Lock()
+	inner.Iterate(func(receipt Receipt) bool {
+		s.track(receipt)
+		return true
+	})
+	s.evictLocked()
+	s.mu.Unlock()
+
+	if options.TTL > 0 {
+		interval := options.SweepInterval
+		if interval <= 0 {
+			interval = max(options.TTL/10, time.Second)
+		}
+		s.stop, s.stopped = make(chan struct{}), make(chan struct{})
+		go s.sweepLoop(interval)
+	}
+	return s
+}
+
+func (s *BoundedStore) Put(receipt Receipt) error {
+	size := receiptSize(receipt)
+	if s.options.MaxBytes > 0 && size > s.options.MaxBytes {
+		return ErrReceiptTooLarge
+	}
+
+	// writes hold the lock so the tracking never disagrees with the inner store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.inner.Put(receipt); err != nil {
+		return err
+	}
+	s.track(receipt)
+	s.evictLocked()
+	return nil
+}
+
+// PutInPlace stores a new version of a tracked receipt without counting it as
+// a fresh write, so it keeps its TTL. SoftDeleteStore uses it to set and clear
+// deletion marks; a deleted receipt moves to the back of the eviction order so
+// it goes before the live ones. A receipt no longer tracked was evicted or
+// expired, and stays gone.
+func (s *BoundedStore) PutInPlace(receipt Receipt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, exists := s.entries[receipt.ID]
+	if !exists {
+		return nil
+	}
+	if err := s.inner.Put(receipt); err != nil {
+		return err
+	}
+	entry := element.Value.(*boundedEntry)
+	size := receiptSize(receipt)
+	s.bytes += size - entry.size
+	entry.size = size
+	if !receipt.DeletedAt.IsZero() {
+		s.order.MoveToBack(element)
+	}
+	s.evictLocked()
+	return nil
+}
+
+func (s *BoundedStore) Get(id string) (Receipt, bool) {
+	s.mu.Lock()
+	element, exists := s.entries[id]
+	if !exists {
+		s.mu.Unlock()
+		return Receipt{}, false
+	}
+	if s.expired(element) {
+		s.removeLocked(element, EvictedTTL)
+		s.mu.Unlock()
+		return Receipt{}, false
+	}
+	if s.options.Policy == EvictLRU {
+		s.order.MoveToFront(element)
+	}
+	s.mu.Unlock()
+	return s.inner.Get(id)
+}
+
+func (s *BoundedStore) List() []Receipt {
+	receipts := s.inner.List()
+	visible := receipts[:0]
+	for _, receipt := range receipts {
+		if s.live(receipt.ID) {
+			visible = append(visible, receipt)
+		}
+	}
+	return visible
+}
+
+func (s *BoundedStore) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, exists := s.entries[id]
+	if !exists {
+		return false, nil
+	}
+	if s.expired(element) {
+		s.removeLocked(element, EvictedTTL)
+		return false, nil
+	}
+
+	existed, err := s.inner.Delete(id)
+	if err != nil {
+		return existed, err
+	}
+	s.forget(element)
+	return existed, nil
+}
+
+// Iterate neither counts as a use for LRU nor visits expired receipts.
+func (s *BoundedStore) Iterate(fn func(Receipt) bool) {
+	s.inner.Iterate(func(receipt Receipt) bool {
+		if !s.live(receipt.ID) {
+			return true
+		}
+		return fn(receipt)
+	})
+}
+
+// Unwrap returns the store that BoundedStore wraps.
+func (s *BoundedStore) Unwrap() ReceiptStore {
+	return s.inner
+}
+
+// Candidates uses the inner store's indexes, if it has any.
+func (s *BoundedStore) Candidates(q ReceiptQuery) ([]Receipt, string) {
+	planner, ok := s.inner.(QueryPlanner)
+	if !ok {
+		return nil, ""
+	}
+	receipts, index := planner.Candidates(q)
+	visible := receipts[:0]
+	for _, receipt := range receipts {
+		if s.live(receipt.ID) {
+			visible = append(visible, receipt)
+		}
+	}
+	return visible, index
+}
+
+// FindDuplicate uses the inner store's index if it has one, else scans what is live.
+func (s *BoundedStore) FindDuplicate(receipt Receipt) (Duplicate, bool) {
+	finder, ok := s.inner.(DuplicateFinder)
+	if !ok {
+		return scanForDuplicate(s, receipt)
+	}
+	previous := ""
+	for {
+		duplicate, found := finder.FindDuplicate(receipt)
+		if !found || s.live(duplicate.Original.ID) {
+			return duplicate, found
+		}
+		if duplicate.Original.ID == previous {
+			// it could not be removed; treat it as gone
+			return Duplicate{}, false
+		}
+		// an expired original is forgotten: Get removes it, then look again
+		previous = duplicate.Original.ID
+		s.Get(previous)
+	}
+}
+
+func (s *BoundedStore) Stats() StoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := StoreStats{Receipts: len(s.entries), Bytes: s.bytes, Evicted: map[string]uint64{}}
+	for _, reason := range []string{EvictedMaxReceipts, EvictedMaxBytes, EvictedTTL} {
+		stats.Evicted[reason] = s.evicted[reason]
+	}
+	return stats
+}
+
+// Sweep removes every expired receipt now.
+func (s *BoundedStore) Sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for element := s.order.Front(); element != nil; {
+		next := element.Next()
+		if s.expired(element) {
+			s.removeLocked(element, EvictedTTL)
+		}
+		element = next
+	}
+}
+
+// Close stops the background sweep; the inner store is left open.
+func (s *BoundedStore) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.stopped
+		s.stop = nil
+	}
+	return nil
+}
+
+func (s *BoundedStore) sweepLoop(interval time.Duration) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.Sweep()
+		}
+	}
+}
+
+func (s *BoundedStore) live(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, exists := s.entries[id]
+	return exists && !s.expired(element)
+}
+
+func (s *BoundedStore) expired(element *list.Element) bool {
+	return s.options.TTL > 0 && s.now().Sub(element.Value.(*boundedEntry).storedAt) >= s.options.TTL
+}
+
+// track records a write: a replaced receipt is resized and counts as new
+func (s *BoundedStore) track(receipt Receipt) {
+	size := receiptSize(receipt)
+	if element, exists := s.entries[receipt.ID]; exists {
+		entry := element.Value.(*boundedEntry)
+		s.bytes += size - entry.size
+		entry.size, entry.storedAt = size, s.now()
+		s.order.MoveToFront(element)
+		return
+	}
+	s.entries[receipt.ID] = s.order.PushFront(&boundedEntry{id: receipt.ID, size: size, storedAt: s.now()})
+	s.bytes += size
+}
+
+func (s *BoundedStore) evictLocked() {
+	for s.order.Len() > 0 {
+		reason := ""
+		switch {
+		case s.options.MaxReceipts > 0 && s.order.Len() > s.options.MaxReceipts:
+			reason = EvictedMaxReceipts
+		case s.options.MaxBytes > 0 && s.bytes > s.options.MaxBytes:
+			reason = EvictedMaxBytes
+		default:
+			return
+		}
+		if !s.removeLocked(s.order.Back(), reason) {
+			return
+		}
+	}
+}
+
+// removeLocked evicts a receipt from the inner store. If that fails the
+// receipt stays tracked, and is tried again on the next eviction.
+func (s *BoundedStore) removeLocked(element *list.Element, reason string) bool {
+	if _, err := s.inner.Delete(element.Value.(*boundedEntry).id); err != nil {
+		return false
+	}
+	s.forget(element)
+	s.evicted[reason]++
+	return true
+}
+
+func (s *BoundedStore) forget(element *list.Element) {
+	entry := element.Value.(*boundedEntry)
+	s.order.Remove(element)
+	delete(s.entries, entry.id)
+	s.bytes -= entry.size
+}
+
+// receiptSize estimates the memory a stored receipt holds: its strings plus a
+// fixed overhead per struct.
+func receiptSize(receipt Receipt) int64 {
+	const structOverhead = 64
+	size := int64(structOverhead + len(receipt.ID) + len(receipt.Retailer) + len(receipt.PurchaseDate) +
+		len(receipt.PurchaseTime) + len(receipt.PurchasedAt) + len(receipt.PurchaseOffset) +
+		len(receipt.Total) + len(receipt.Currency) + len(receipt.MemberID))
+	for _, item := range receipt.Items {
+		size += int64(structOverhead + len(item.ShortDescription) + len(item.Price))
+	}
+	for _, warning := range receipt.Warnings {
+		size += int64(structOverhead + len(warning.Pointer) + len(warning.Code) + len(warning.Message))
+	}
+	if raw := receipt.Raw; raw != nil {
+		size += int64(structOverhead + len(raw.PurchaseDate) + len(raw.PurchaseTime) + len(raw.PurchasedAt) +
+			len(raw.DateFormat) + len(raw.TimeFormat) + len(raw.Total))
+		for _, item := range raw.Items {
+			size += int64(structOverhead + len(item.ShortDescription) + len(item.Price))
+		}
+	}
+	for _, revision := range receipt.History {
+		size += structOverhead + receiptSize(revision.Receipt)
+	}
+	return size
+}
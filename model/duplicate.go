@@ -0,0 +1,152 @@
+// model/duplicate.go
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DuplicatePolicy decides what happens to a receipt that matches one already stored.
+type DuplicatePolicy string
+
+const (
+	// DuplicateReject refuses the receipt and points at the original.
+	DuplicateReject DuplicatePolicy = "reject"
+	// DuplicateFlag stores the receipt with a possible_duplicate warning.
+	DuplicateFlag DuplicatePolicy = "flag"
+	// DuplicateAccept stores the receipt without checking.
+	DuplicateAccept DuplicatePolicy = "accept"
+)
+
+// ActiveDuplicatePolicy is applied when receipts are stored; main.go overrides it from flags.
+var ActiveDuplicatePolicy = DuplicateAccept
+
+// NearDuplicateWindow is how far apart two otherwise identical receipts'
+// purchase times can be and still count as the same receipt.
+var NearDuplicateWindow = time.Minute
+
+const WarningPossibleDuplicate = "possible_duplicate"
+
+func ParseDuplicatePolicy(policy string) (DuplicatePolicy, error) {
+	switch DuplicatePolicy(strings.ToLower(strings.TrimSpace(policy))) {
+	case DuplicateReject:
+		return DuplicateReject, nil
+	case DuplicateFlag:
+		return DuplicateFlag, nil
+	case DuplicateAccept:
+		return DuplicateAccept, nil
+	}
+	return "", fmt.Errorf("unknown duplicate policy %q (expected %s, %s or %s)", policy, DuplicateReject, DuplicateFlag, DuplicateAccept)
+}
+
+// Duplicate is a stored receipt that a new one matches. Exact means the
+// fingerprints are equal; otherwise only the purchase times differ, by at
+// most NearDuplicateWindow.
+type Duplicate struct {
+	Original Receipt
+	Exact    bool
+}
+
+func (d Duplicate) Warning() Warning {
+	kind := "is a near-duplicate of"
+	if d.Exact {
+		kind = "is an exact duplicate of"
+	}
+	return Warning{Code: WarningPossibleDuplicate, Message: fmt.Sprintf("this receipt %s receipt %s", kind, d.Original.ID)}
+}
+
+// DuplicateFinder is a store with its own index of receipt contents.
+type DuplicateFinder interface {
+	FindDuplicate(receipt Receipt) (Duplicate, bool)
+}
+
+// FindDuplicate looks for a stored receipt matching a normalized one, using the
+// store's index when it has one and a scan otherwise.
+func FindDuplicate(store ReceiptStore, receipt Receipt) (Duplicate, bool) {
+	if finder, ok := store.(DuplicateFinder); ok {
+		return finder.FindDuplicate(receipt)
+	}
+	return scanForDuplicate(store, receipt)
+}
+
+func scanForDuplicate(store ReceiptStore, receipt Receipt) (Duplicate, bool) {
+	var candidates []Receipt
+	key := receipt.ContentKey()
+	store.Iterate(func(stored Receipt) bool {
+		if stored.ContentKey() == key {
+			candidates = append(candidates, stored)
+		}
+		return true
+	})
+	return closestDuplicate(receipt, candidates)
+}
+
+// closestDuplicate picks, among receipts with the same content key, an exact
+// match over a near one, then the lowest ID so the answer is stable.
+func closestDuplicate(receipt Receipt, candidates []Receipt) (Duplicate, bool) {
+	var best Duplicate
+	found := false
+	fingerprint := receipt.Fingerprint()
+	at, hasTime := purchaseMinute(receipt)
+	for _, candidate := range candidates {
+		if candidate.ID == receipt.ID {
+			continue
+		}
+		exact := candidate.Fingerprint() == fingerprint
+		if !exact {
+			candidateAt, ok := purchaseMinute(candidate)
+			if !hasTime || !ok || candidateAt.Sub(at).Abs() > NearDuplicateWindow {
+				continue
+			}
+		}
+		if !found || (exact && !best.Exact) || (exact == best.Exact && candidate.ID < best.Original.ID) {
+			best, found = Duplicate{Original: candidate, Exact: exact}, true
+		}
+	}
+	return best, found
+}
+
+// Fingerprint is a canonical hash of a normalized receipt: retailer, purchase
+// date and time, total and items, ignoring case, spacing, item order and
+// how amounts are written.
+func (r Receipt) Fingerprint() string {
+	return hashParts(r.ContentKey(), r.PurchaseDate, r.PurchaseTime)
+}
+
+// ContentKey is the Fingerprint without the purchase date and time, so
+// receipts a minute apart can be found together.
+func (r Receipt) ContentKey() string {
+	items := make([]string, 0, len(r.Items))
+	for _, item := range r.Items {
+		quantity := item.Quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+		items = append(items, fmt.Sprintf("%s|%s|%d", NormalizeRetailer(item.ShortDescription), canonicalAmount(item.Price), quantity))
+	}
+	sort.Strings(items)
+	return hashParts(append([]string{NormalizeRetailer(r.Retailer), canonicalAmount(r.Total)}, items...)...)
+}
+
+func hashParts(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalAmount writes "6.5" and "6.50" the same way
+func canonicalAmount(amount string) string {
+	if value, err := strconv.ParseFloat(amount, 64); err == nil {
+		return strconv.FormatFloat(value, 'f', 2, 64)
+	}
+	return amount
+}
+
+func purchaseMinute(r Receipt) (time.Time, bool) {
+	at, err := time.Parse("2006-01-02 15:04", r.PurchaseDate+" "+r.PurchaseTime)
+	return at, err == nil
+}
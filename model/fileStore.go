@@ -0,0 +1,464 @@
+// model/fileStore.go
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SyncMode says when the write-ahead log is fsynced.
+type SyncMode string
+
+const (
+	// SyncAlways fsyncs before every Put/Delete returns: nothing acknowledged is lost.
+	SyncAlways SyncMode = "always"
+	// SyncInterval fsyncs in the background every SyncInterval; a crash can lose
+	// that much acknowledged work.
+	SyncInterval SyncMode = "interval"
+	// SyncNever leaves flushing to the OS.
+	SyncNever SyncMode = "never"
+)
+
+func ParseSyncMode(mode string) (SyncMode, error) {
+	switch SyncMode(mode) {
+	case SyncAlways, SyncInterval, SyncNever:
+		return SyncMode(mode), nil
+	}
+	return "", fmt.Errorf("unknown fsync mode %q (expected %s, %s or %s)", mode, SyncAlways, SyncInterval, SyncNever)
+}
+
+type FileStoreOptions struct {
+	Sync SyncMode
+	// SyncInterval is the background fsync period for SyncInterval (default 1s)
+	SyncInterval time.Duration
+	// SnapshotEvery compacts the log into a snapshot after this many records; 0 never does
+	SnapshotEvery int
+}
+
+// RecoveryStats describes what OpenFileStore found on disk.
+type RecoveryStats struct {
+	SnapshotReceipts int
+	ReplayedRecords  int
+	// TruncatedBytes is the torn or corrupt tail cut off the log (e.g. a crash mid-write)
+	TruncatedBytes int64
+}
+
+var ErrStoreClosed = errors.New("receipt store is closed")
+
+const (
+	walFileName      = "receipts.wal"
+	snapshotFileName = "receipts.snapshot"
+	// a record header is the payload length and its CRC-32C, both big-endian uint32
+	recordHeaderSize = 8
+	maxRecordSize    = 64 << 20
+
+	// a failed snapshot is retried after a backoff that doubles from
+	// snapshotRetryMin up to snapshotRetryMax
+	snapshotRetryMin = time.Second
+	snapshotRetryMax = 5 * time.Minute
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type walOp uint8
+
+const (
+	opPut walOp = iota + 1
+	opDelete
+)
+
+// walRecord is one mutation. It is gob-encoded, so every exported Receipt field
+// is kept, including the v2-only ones JSON leaves out.
+type walRecord struct {
+	Op      walOp
+	ID      string
+	Receipt Receipt
+}
+
+var errBadRecord = errors.New("torn or corrupt record")
+
+// walFile is the open log; an *os.File outside tests
+type walFile interface {
+	io.WriteSeeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// FileStore is a ReceiptStore that survives restarts. Every mutation is
+// appended to a write-ahead log before it is applied in memory; the log is
+// periodically compacted into a snapshot. Reads are served from memory.
+type FileStore struct {
+	*MemoryStore
+	dir      string
+	options  FileStoreOptions
+	recovery RecoveryStats
+
+	mu         sync.Mutex // serializes log writes
+	wal        walFile
+	walRecords int
+	dirty      bool // written but not yet fsynced
+	closed     bool
+	stop       chan struct{}
+	stopped    chan struct{}
+
+	// snapshotRetry is the backoff after a failed snapshot, until snapshotRetryAt
+	snapshotRetry   time.Duration
+	snapshotRetryAt time.Time
+}
+
+// OpenFileStore loads dir (creating it if needed): the snapshot first, then
+// every intact log record after it. A torn or corrupt tail is cut off the log.
+func OpenFileStore(dir string, options FileStoreOptions) (*FileStore, error) {
+	if options.Sync == "" {
+		options.Sync = SyncAlways
+	}
+	if _, err := ParseSyncMode(string(options.Sync)); err != nil {
+		return nil, err
+	}
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = time.Second
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{MemoryStore: NewMemoryStore(), dir: dir, options: options}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replayLog(); err != nil {
+		return nil, err
+	}
+
+	if options.Sync == SyncInterval {
+		s.stop, s.stopped = make(chan struct{}), make(chan struct{})
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+func (s *FileStore) Recovery() RecoveryStats {
+	return s.recovery
+}
+
+func (s *FileStore) loadSnapshot() error {
+	file, err := os.Open(filepath.Join(s.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// snapshots are renamed into place whole, so any damage is real corruption
+	_, err = readRecords(bufio.NewReader(file), func(record walRecord) {
+		s.apply(record)
+		s.recovery.SnapshotReceipts++
+	})
+	if err != nil {
+		return fmt.Errorf("snapshot %s: %w", file.Name(), err)
+	}
+	return nil
+}
+
+func (s *FileStore) replayLog() error {
+	wal, err := os.OpenFile(filepath.Join(s.dir, walFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	good, err := readRecords(bufio.NewReader(wal), func(record walRecord) {
+		s.apply(record)
+		s.recovery.ReplayedRecords++
+	})
+	if err != nil && !errors.Is(err, errBadRecord) {
+		wal.Close()
+		return err
+	}
+
+	info, statErr := wal.Stat()
+	if statErr != nil {
+		wal.Close()
+		return statErr
+	}
+	if info.Size() > good {
+		// cut the damaged tail so new records are not appended behind it
+		s.recovery.TruncatedBytes = info.Size() - good
+		if err := wal.Truncate(good); err != nil {
+			wal.Close()
+			return err
+		}
+		if err := wal.Sync(); err != nil {
+			wal.Close()
+			return err
+		}
+	}
+	if _, err := wal.Seek(good, io.SeekStart); err != nil {
+		wal.Close()
+		return err
+	}
+
+	s.wal, s.walRecords = wal, s.recovery.ReplayedRecords
+	return nil
+}
+
+func (s *FileStore) apply(record walRecord) {
+	switch record.Op {
+	case opPut:
+		s.MemoryStore.Put(record.Receipt)
+	case opDelete:
+		s.MemoryStore.Delete(record.ID)
+	}
+}
+
+func (s *FileStore) Put(receipt Receipt) error {
+	_, err := s.write(walRecord{Op: opPut, ID: receipt.ID, Receipt: receipt})
+	return err
+}
+
+func (s *FileStore) Delete(id string) (bool, error) {
+	return s.write(walRecord{Op: opDelete, ID: id})
+}
+
+// write logs the record (fsyncing per the SyncMode) and only then applies it.
+// A write or fsync that fails is cut back off the log and not applied, so a
+// record is never replayed for a change the caller was told failed. A delete
+// of a missing receipt is not logged and reports false; the check is under
+// s.mu so of two concurrent deletes only one logs and reports true.
+func (s *FileStore) write(record walRecord) (bool, error) {
+	data, err := encodeRecord(record)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false, ErrStoreClosed
+	}
+	if record.Op == opDelete {
+		if _, exists := s.MemoryStore.Get(record.ID); !exists {
+			return false, nil
+		}
+	}
+	offset, err := s.wal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.wal.Write(data); err != nil {
+		return false, s.rollbackLocked(offset, err)
+	}
+	if s.options.Sync == SyncAlways {
+		if err := s.wal.Sync(); err != nil {
+			return false, s.rollbackLocked(offset, err)
+		}
+	} else {
+		s.dirty = true
+	}
+	s.apply(record)
+
+	s.walRecords++
+	if s.options.SnapshotEvery > 0 && s.walRecords >= s.options.SnapshotEvery && !time.Now().Before(s.snapshotRetryAt) {
+		// the record is already durable in the log, so a failed snapshot only
+		// lets the log grow until the retry
+		if err := s.snapshotLocked(); err != nil {
+			s.snapshotRetry = min(max(2*s.snapshotRetry, snapshotRetryMin), snapshotRetryMax)
+			s.snapshotRetryAt = time.Now().Add(s.snapshotRetry)
+			log.Printf("file store: snapshot of %s failed, retrying in %s: %v", s.dir, s.snapshotRetry, err)
+		}
+	}
+	return true, nil
+}
+
+// rollbackLocked truncates the log back to offset after a failed write
+func (s *FileStore) rollbackLocked(offset int64, err error) error {
+	if truncateErr := s.wal.Truncate(offset); truncateErr != nil {
+		return errors.Join(err, truncateErr)
+	}
+	if _, seekErr := s.wal.Seek(offset, io.SeekStart); seekErr != nil {
+		return errors.Join(err, seekErr)
+	}
+	return err
+}
+
+// Snapshot compacts the current state into the snapshot file and empties the log.
+func (s *FileStore) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	return s.snapshotLocked()
+}
+
+func (s *FileStore) snapshotLocked() error {
+	path := filepath.Join(s.dir, snapshotFileName)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	writer := bufio.NewWriter(tmp)
+	for _, receipt := range s.MemoryStore.List() {
+		data, err := encodeRecord(walRecord{Op: opPut, ID: receipt.ID, Receipt: receipt})
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	// a crash before this point replays the old log over the new snapshot,
+	// which is harmless: puts and deletes are idempotent
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	s.walRecords, s.dirty = 0, false
+	s.snapshotRetry, s.snapshotRetryAt = 0, time.Time{}
+	return nil
+}
+
+func (s *FileStore) syncLoop() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty && !s.closed {
+				if s.wal.Sync() == nil {
+					s.dirty = false
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Close fsyncs and closes the log; later writes fail with ErrStoreClosed.
+func (s *FileStore) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.stopped
+		s.stop = nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.wal.Sync()
+	if closeErr := s.wal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func encodeRecord(record walRecord) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(record); err != nil {
+		return nil, err
+	}
+	if payload.Len() > maxRecordSize {
+		return nil, fmt.Errorf("receipt %s is too large to store (%d bytes)", record.ID, payload.Len())
+	}
+
+	data := make([]byte, recordHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(data[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(data[4:8], crc32.Checksum(payload.Bytes(), crcTable))
+	copy(data[recordHeaderSize:], payload.Bytes())
+	return data, nil
+}
+
+// readRecords calls fn for each intact record and returns the offset just past
+// the last one. A short, oversized or checksum-failing record ends the read
+// with errBadRecord.
+func readRecords(reader io.Reader, fn func(walRecord)) (int64, error) {
+	var offset int64
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return offset, nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return offset, errBadRecord
+			}
+			return offset, err
+		}
+
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size == 0 || size > maxRecordSize {
+			return offset, errBadRecord
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return offset, errBadRecord
+			}
+			return offset, err
+		}
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return offset, errBadRecord
+		}
+
+		var record walRecord
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&record); err != nil {
+			return offset, errBadRecord
+		}
+		fn(record)
+		offset += recordHeaderSize + int64(size)
+	}
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,316 @@
+// model/indexedStore.go
+package model
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// Index names reported by QueryPlanner.Candidates.
+const (
+	IndexRetailer     = "retailer"
+	IndexPurchaseDate = "purchaseDate"
+	IndexPoints       = "points"
+)
+
+// QueryPlanner is a store that can narrow a query without a full scan.
+type QueryPlanner interface {
+	// Candidates returns a superset of the receipts matching q and the index
+	// used to find them, or "" when no index applies and the caller should scan.
+	Candidates(q ReceiptQuery) ([]Receipt, string)
+}
+
+// IndexedStore wraps another store with secondary indexes: normalized retailer
+// to IDs, purchase date and points with their keys kept sorted for range
+// queries, and content key to IDs for duplicate detection. Writes to the same
+// ID are ordered by a striped lock, so the indexes always match the inner
+// store, while writes to different IDs only share the brief index update.
+type IndexedStore struct {
+	inner   ReceiptStore
+	stripes [indexStripes]struct {
+		sync.Mutex
+		// keep each stripe's lock on its own cache line
+		_ [56]byte
+	}
+
+	mu        sync.RWMutex
+	indexed   map[string]indexedFields
+	retailers map[string]map[string]struct{}
+	contents  map[string]map[string]struct{}
+	dates     orderedIndex[string]
+	points    orderedIndex[uint]
+}
+
+const indexStripes = 64
+
+// indexedFields are what a receipt was indexed under, to unindex it later
+type indexedFields struct {
+	retailer string
+	content  string
+	date     string
+	points   uint
+}
+
+// NewIndexedStore indexes the receipts already in inner.
+func NewIndexedStore(inner ReceiptStore) *IndexedStore {
+	s := &IndexedStore{
+		inner:     inner,
+		indexed:   make(map[string]indexedFields),
+		retailers: make(map[string]map[string]struct{}),
+		contents:  make(map[string]map[string]struct{}),
+		dates:     newOrderedIndex[string](),
+		points:    newOrderedIndex[uint](),
+	}
+	s.mu.Lock()
+	inner.Iterate(func(receipt Receipt) bool {
+		s.index(receipt.ID, fieldsOf(receipt))
+		return true
+	})
+	s.mu.Unlock()
+	return s
+}
+
+func (s *IndexedStore) stripe(id string) *sync.Mutex {
+	return &s.stripes[fnv32a(id)%indexStripes].Mutex
+}
+
+func (s *IndexedStore) Put(receipt Receipt) error {
+	stripe := s.stripe(receipt.ID)
+	stripe.Lock()
+	defer stripe.Unlock()
+	if err := s.inner.Put(receipt); err != nil {
+		return err
+	}
+	fields := fieldsOf(receipt)
+	s.mu.Lock()
+	s.unindex(receipt.ID)
+	s.index(receipt.ID, fields)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *IndexedStore) Get(id string) (Receipt, bool) {
+	return s.inner.Get(id)
+}
+
+func (s *IndexedStore) List() []Receipt {
+	return s.inner.List()
+}
+
+func (s *IndexedStore) Delete(id string) (bool, error) {
+	stripe := s.stripe(id)
+	stripe.Lock()
+	defer stripe.Unlock()
+	existed, err := s.inner.Delete(id)
+	if err != nil {
+		return existed, err
+	}
+	s.mu.Lock()
+	s.unindex(id)
+	s.mu.Unlock()
+	return existed, nil
+}
+
+func (s *IndexedStore) Iterate(fn func(Receipt) bool) {
+	s.inner.Iterate(fn)
+}
+
+// Unwrap returns the store that IndexedStore wraps.
+func (s *IndexedStore) Unwrap() ReceiptStore {
+	return s.inner
+}
+
+// Candidates picks whichever index narrows q to the fewest receipts; ranges
+// are counted before any IDs are collected, and the receipts are read after
+// the index lock is released so a large result never holds up writes.
+func (s *IndexedStore) Candidates(q ReceiptQuery) ([]Receipt, string) {
+	index, ids := s.plan(q)
+	if index == "" {
+		return nil, ""
+	}
+	return s.getAll(ids), index
+}
+
+func (s *IndexedStore) plan(q ReceiptQuery) (string, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	index, size := "", 0
+	var collect func() []string
+	consider := func(name string, n int, ids func() []string) {
+		if index == "" || n < size {
+			index, size, collect = name, n, ids
+		}
+	}
+
+	if q.Retailer != "" {
+		set := s.retailers[NormalizeRetailer(q.Retailer)]
+		consider(IndexRetailer, len(set), func() []string { return setIDs(set) })
+	}
+	if q.From != "" || q.To != "" {
+		var from, to *string
+		if q.From != "" {
+			from = &q.From
+		}
+		if q.To != "" {
+			to = &q.To
+		}
+		lo, hi := s.dates.bounds(from, to)
+		consider(IndexPurchaseDate, s.dates.count(lo, hi), func() []string { return s.dates.ids(lo, hi) })
+	}
+	if q.MinPoints != nil || q.MaxPoints != nil {
+		lo, hi := s.points.bounds(q.MinPoints, q.MaxPoints)
+		consider(IndexPoints, s.points.count(lo, hi), func() []string { return s.points.ids(lo, hi) })
+	}
+	if index == "" {
+		return "", nil
+	}
+	return index, collect()
+}
+
+// FindDuplicate only compares receipts with the same content key.
+func (s *IndexedStore) FindDuplicate(receipt Receipt) (Duplicate, bool) {
+	s.mu.RLock()
+	ids := setIDs(s.contents[receipt.ContentKey()])
+	s.mu.RUnlock()
+	return closestDuplicate(receipt, s.getAll(ids))
+}
+
+// getAll reads receipts from the inner store, skipping any removed meanwhile
+func (s *IndexedStore) getAll(ids []string) []Receipt {
+	receipts := make([]Receipt, 0, len(ids))
+	for _, id := range ids {
+		if receipt, exists := s.inner.Get(id); exists {
+			receipts = append(receipts, receipt)
+		}
+	}
+	return receipts
+}
+
+// fieldsOf hashes the content key, so writers call it before taking s.mu
+func fieldsOf(receipt Receipt) indexedFields {
+	return indexedFields{
+		retailer: NormalizeRetailer(receipt.Retailer),
+		content:  receipt.ContentKey(),
+		date:     receipt.PurchaseDate,
+		points:   receipt.Points,
+	}
+}
+
+func (s *IndexedStore) index(id string, fields indexedFields) {
+	s.indexed[id] = fields
+
+	addToSet(s.retailers, fields.retailer, id)
+	addToSet(s.contents, fields.content, id)
+	s.dates.insert(fields.date, id)
+	s.points.insert(fields.points, id)
+}
+
+func (s *IndexedStore) unindex(id string) {
+	fields, exists := s.indexed[id]
+	if !exists {
+		return
+	}
+	delete(s.indexed, id)
+
+	removeFromSet(s.retailers, fields.retailer, id)
+	removeFromSet(s.contents, fields.content, id)
+	s.dates.remove(fields.date, id)
+	s.points.remove(fields.points, id)
+}
+
+func addToSet[K comparable](sets map[K]map[string]struct{}, key K, id string) {
+	set, exists := sets[key]
+	if !exists {
+		set = make(map[string]struct{})
+		sets[key] = set
+	}
+	set[id] = struct{}{}
+}
+
+// removeFromSet reports whether the key's set is now gone
+func removeFromSet[K comparable](sets map[K]map[string]struct{}, key K, id string) bool {
+	set := sets[key]
+	delete(set, id)
+	if len(set) == 0 {
+		delete(sets, key)
+		return true
+	}
+	return false
+}
+
+func setIDs(set map[string]struct{}) []string {
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// orderedIndex maps each key to its IDs and keeps the distinct keys sorted for
+// range lookups by binary search. Purchase dates and point totals repeat, so
+// the sorted keys only change when a key is first added or last removed; the
+// common write is a map update.
+type orderedIndex[K cmp.Ordered] struct {
+	keys []K
+	sets map[K]map[string]struct{}
+}
+
+func newOrderedIndex[K cmp.Ordered]() orderedIndex[K] {
+	return orderedIndex[K]{sets: make(map[K]map[string]struct{})}
+}
+
+func (x *orderedIndex[K]) insert(key K, id string) {
+	if _, exists := x.sets[key]; !exists {
+		i, _ := slices.BinarySearch(x.keys, key)
+		x.keys = slices.Insert(x.keys, i, key)
+	}
+	addToSet(x.sets, key, id)
+}
+
+func (x *orderedIndex[K]) remove(key K, id string) {
+	if _, exists := x.sets[key]; !exists {
+		return
+	}
+	if removeFromSet(x.sets, key, id) {
+		if i, found := slices.BinarySearch(x.keys, key); found {
+			x.keys = slices.Delete(x.keys, i, i+1)
+		}
+	}
+}
+
+// bounds is the range of keys with from <= key <= to; a nil bound is open
+func (x *orderedIndex[K]) bounds(from, to *K) (int, int) {
+	lo, hi := 0, len(x.keys)
+	if from != nil {
+		lo, _ = slices.BinarySearch(x.keys, *from)
+	}
+	if to != nil {
+		// first key past to
+		var found bool
+		if hi, found = slices.BinarySearch(x.keys, *to); found {
+			hi++
+		}
+	}
+	return lo, max(lo, hi)
+}
+
+// count is how many IDs the keys in [lo, hi) hold
+func (x *orderedIndex[K]) count(lo, hi int) int {
+	n := 0
+	for _, key := range x.keys[lo:hi] {
+		n += len(x.sets[key])
+	}
+	return n
+}
+
+func (x *orderedIndex[K]) ids(lo, hi int) []string {
+	ids := make([]string, 0, x.count(lo, hi))
+	for _, key := range x.keys[lo:hi] {
+		for id := range x.sets[key] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,229 @@
+// model/query.go
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sort keys for ReceiptQuery.Sort; prefix with "-" for descending order.
+const (
+	SortPurchaseDate = "purchaseDate"
+	SortPoints       = "points"
+	SortTotal        = "total"
+)
+
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// ReceiptQuery filters, sorts and pages stored receipts.
+// Zero values mean "no filter"; ties are always broken by ID so paging is stable.
+type ReceiptQuery struct {
+	Retailer  string // case/whitespace-insensitive exact match
+	From      string // inclusive YYYY-MM-DD
+	To        string // inclusive YYYY-MM-DD
+	MinTotal  *float64
+	MaxTotal  *float64
+	MinPoints *uint
+	MaxPoints *uint
+	Sort      string
+	Limit     int
+	Cursor    string // opaque, from a previous ReceiptPage.Next
+}
+
+// ReceiptPage is one page of results; Next is empty on the last page.
+type ReceiptPage struct {
+	Receipts []Receipt `json:"receipts" xml:"receipt"`
+	Next     string    `json:"next,omitempty" xml:"next,attr,omitempty"`
+}
+
+// pageCursor marks the last receipt of a page by its sort key and ID.
+type pageCursor struct {
+	Sort string  `json:"s"`
+	Key  string  `json:"k,omitempty"`
+	Num  float64 `json:"n,omitempty"`
+	ID   string  `json:"id"`
+}
+
+// sortKey is the comparable value a receipt is ordered by.
+type sortKey struct {
+	str string
+	num float64
+	id  string
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// NormalizeRetailer lowercases and collapses whitespace so "M&M  corner market"
+// and "M&M Corner Market" compare equal.
+func NormalizeRetailer(retailer string) string {
+	return strings.ToLower(strings.Join(strings.Fields(retailer), " "))
+}
+
+// QueryReceipts runs q against the receipts an index narrows it to, when the
+// store is a QueryPlanner with one that applies, else against every receipt.
+func QueryReceipts(store ReceiptStore, q ReceiptQuery) (ReceiptPage, error) {
+	if planner, ok := store.(QueryPlanner); ok {
+		if candidates, index := planner.Candidates(q); index != "" {
+			return q.Run(candidates)
+		}
+	}
+	return q.Run(store.List())
+}
+
+// Run filters, sorts and pages receipts.
+func (q ReceiptQuery) Run(receipts []Receipt) (ReceiptPage, error) {
+	field, descending, err := parseSort(q.Sort)
+	if err != nil {
+		return ReceiptPage{}, err
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	var after *sortKey
+	if q.Cursor != "" {
+		cursor, err := decodeCursor(q.Cursor)
+		if err != nil || cursor.Sort != q.sortSpec() {
+			return ReceiptPage{}, ErrInvalidCursor
+		}
+		after = &sortKey{str: cursor.Key, num: cursor.Num, id: cursor.ID}
+	}
+
+	type keyed struct {
+		key     sortKey
+		receipt Receipt
+	}
+	matched := make([]keyed, 0, len(receipts))
+	for _, receipt := range receipts {
+		if !q.Matches(receipt) {
+			continue
+		}
+		key := receiptSortKey(receipt, field)
+		if after != nil && compareSortKeys(key, *after, descending) <= 0 {
+			continue
+		}
+		matched = append(matched, keyed{key, receipt})
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return compareSortKeys(matched[i].key, matched[j].key, descending) < 0
+	})
+
+	page := ReceiptPage{Receipts: []Receipt{}}
+	for i := 0; i < len(matched) && i < limit; i++ {
+		page.Receipts = append(page.Receipts, matched[i].receipt)
+	}
+	if len(matched) > limit {
+		last := matched[limit-1].key
+		page.Next = encodeCursor(pageCursor{Sort: q.sortSpec(), Key: last.str, Num: last.num, ID: last.id})
+	}
+	return page, nil
+}
+
+// Matches reports whether a receipt passes every filter in q.
+func (q ReceiptQuery) Matches(receipt Receipt) bool {
+	if q.Retailer != "" && NormalizeRetailer(receipt.Retailer) != NormalizeRetailer(q.Retailer) {
+		return false
+	}
+	// ISO dates compare correctly as strings
+	if q.From != "" && receipt.PurchaseDate < q.From {
+		return false
+	}
+	if q.To != "" && receipt.PurchaseDate > q.To {
+		return false
+	}
+	if q.MinTotal != nil || q.MaxTotal != nil {
+		total, err := strconv.ParseFloat(receipt.Total, 64)
+		if err != nil {
+			return false
+		}
+		if (q.MinTotal != nil && total < *q.MinTotal) || (q.MaxTotal != nil && total > *q.MaxTotal) {
+			return false
+		}
+	}
+	if q.MinPoints != nil && receipt.Points < *q.MinPoints {
+		return false
+	}
+	if q.MaxPoints != nil && receipt.Points > *q.MaxPoints {
+		return false
+	}
+	return true
+}
+
+func (q ReceiptQuery) sortSpec() string {
+	if q.Sort == "" {
+		return SortPurchaseDate
+	}
+	return q.Sort
+}
+
+func parseSort(spec string) (string, bool, error) {
+	field := strings.TrimPrefix(spec, "-")
+	descending := strings.HasPrefix(spec, "-")
+	switch field {
+	case "":
+		return SortPurchaseDate, false, nil
+	case SortPurchaseDate, SortPoints, SortTotal:
+		return field, descending, nil
+	}
+	return "", false, fmt.Errorf("unknown sort key %q (expected %s, %s or %s, optionally prefixed with -)",
+		spec, SortPurchaseDate, SortPoints, SortTotal)
+}
+
+func receiptSortKey(receipt Receipt, field string) sortKey {
+	key := sortKey{id: receipt.ID}
+	switch field {
+	case SortPurchaseDate:
+		key.str = receipt.PurchaseDate + " " + receipt.PurchaseTime
+	case SortPoints:
+		key.num = float64(receipt.Points)
+	case SortTotal:
+		key.num, _ = strconv.ParseFloat(receipt.Total, 64)
+	}
+	return key
+}
+
+// compareSortKeys orders by value (reversed when descending), then always by ascending ID.
+func compareSortKeys(a, b sortKey, descending bool) int {
+	c := strings.Compare(a.str, b.str)
+	if c == 0 && a.num != b.num {
+		c = -1
+		if a.num > b.num {
+			c = 1
+		}
+	}
+	if descending {
+		c = -c
+	}
+	if c == 0 {
+		c = strings.Compare(a.id, b.id)
+	}
+	return c
+}
+
+func encodeCursor(cursor pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(encoded string) (pageCursor, error) {
+	var cursor pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
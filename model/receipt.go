@@ -1,7 +1,6 @@
 // model/receipt.go
 package model
 import (
-	"sync"
 	"errors"
 	"fmt"
 	"math"
@@ -12,63 +11,135 @@ import (
 
 	"strings"
 	"regexp"
+	"time"
 )
 type Item struct {
-	ShortDescription string `json:"shortDescription"`
-	Price            string `json:"price"`
+	ShortDescription string `json:"shortDescription" xml:"shortDescription"`
+	Price            string `json:"price" xml:"price"`
+	// Quantity is set by v2 clients (Price is then the line total); v1 bodies never carry it
+	Quantity uint `json:"-" xml:"-"`
 }
 type Receipt struct {
-	Retailer     string `json:"retailer"`
-	PurchaseDate string `json:"purchaseDate"`
-	PurchaseTime string `json:"purchaseTime"`
-	Items        []Item `json:"items"`
-	Total        string `json:"total"`
-	ID           string `json:"id"`
-	Points       uint   `json:"points"`
-}
-var (
-	receipts    = make(map[string]Receipt)
-	receiptsMux sync.Mutex
+	Retailer     string `json:"retailer" xml:"retailer"`
+	PurchaseDate string `json:"purchaseDate" xml:"purchaseDate"`
+	PurchaseTime string `json:"purchaseTime" xml:"purchaseTime"`
+	// PurchasedAt is an optional RFC 3339 alternative to PurchaseDate + PurchaseTime.
+	PurchasedAt    string `json:"purchasedAt,omitempty" xml:"purchasedAt,omitempty"`
+	PurchaseOffset string `json:"purchaseOffset,omitempty" xml:"purchaseOffset,omitempty"`
+	Items        []Item `json:"items" xml:"items>item"`
+	Total        string `json:"total" xml:"total"`
+	ID           string `json:"id" xml:"id"`
+	Points       uint   `json:"points" xml:"points"`
+	// Breakdown is the rule-by-rule split of Points as scored, kept so later
+	// calendar changes do not rewrite it
+	Breakdown PointsBreakdown `json:"-" xml:"-"`
+	// Raw keeps the strings exactly as submitted; only exposed with ?view=raw
+	Raw *RawInput `json:"raw,omitempty" xml:"raw,omitempty"`
+	// Warnings are non-fatal data-quality issues found while normalizing
+	Warnings []Warning `json:"warnings,omitempty" xml:"warnings>warning,omitempty"`
+	// v2-only fields, kept out of the v1 JSON/XML shape
+	Currency string `json:"-" xml:"-"`
+	MemberID string `json:"-" xml:"-"`
+	// Revision counts edits from 1 and History keeps each replaced version;
+	// both are served by their own endpoint rather than in the receipt body
+	Revision uint       `json:"-" xml:"-"`
+	History  []Revision `json:"-" xml:"-"`
+	// DeletedAt is set while a SoftDeleteStore holds the receipt for restore
+	DeletedAt time.Time `json:"-" xml:"-"`
+}
+
+// Warning describes input that was accepted but changed or guessed at.
+// Pointer is a JSON pointer to the field, e.g. /items/2/shortDescription.
+type Warning struct {
+	Pointer string `json:"pointer" xml:"pointer,attr"`
+	Code    string `json:"code" xml:"code,attr"`
+	Message string `json:"message" xml:",chardata"`
+}
+
+const (
+	WarningAmbiguousDate        = "ambiguous_date"
+	WarningSecondsDropped       = "seconds_dropped"
+	WarningDescriptionCollapsed = "description_collapsed"
 )
+
+// RawInput is the receipt as the customer submitted it, before normalization,
+// plus the names of the DateFormat/TimeFormat that matched.
+type RawInput struct {
+	PurchaseDate string `json:"purchaseDate,omitempty" xml:"purchaseDate,omitempty"`
+	PurchaseTime string `json:"purchaseTime,omitempty" xml:"purchaseTime,omitempty"`
+	PurchasedAt  string `json:"purchasedAt,omitempty" xml:"purchasedAt,omitempty"`
+	DateFormat   string `json:"dateFormat,omitempty" xml:"dateFormat,omitempty"`
+	TimeFormat   string `json:"timeFormat,omitempty" xml:"timeFormat,omitempty"`
+	Items        []Item `json:"items" xml:"items>item"`
+	Total        string `json:"total" xml:"total"`
+}
+const standardErrorPrefix = "error processing receipt:\n   "
+
 func (r *Receipt) GenerateUniqueID() {
 	r.ID = uuid.New().String()
 }
 
 func (receipt *Receipt) ValidateReceipt() error {
-	standardErrorPrefix := "error processing receipt:\n   "
+	return receipt.ValidateReceiptWithProfile(DefaultValidationProfile)
+}
+
+// ValidateReceiptWithProfile collects every problem with the receipt rather than
+// stopping at the first; the returned error is a ValidationErrors.
+func (receipt *Receipt) ValidateReceiptWithProfile(profile ValidationProfile) error {
+	var errs ValidationErrors
+
 	if receipt.Retailer == "" {
-		return errors.New(standardErrorPrefix + "retailer cannot be empty")
+		errs.add("/retailer", CodeRequired, "retailer cannot be empty", nil)
 	}
 
-    // Use consolidated date validation
-    if _, err := config.ValidateAndFormatDate(receipt.PurchaseDate); err != nil {
-        return fmt.Errorf("%s%v", standardErrorPrefix, err)
-    }
+	purchase, purchaseOk := receipt.validatePurchaseDateTime(&errs)
 
-    // Use consolidated time validation
-    if _, err := config.ValidateAndFormatTime(receipt.PurchaseTime); err != nil {
-        return fmt.Errorf("%s%v", standardErrorPrefix, err)
-    }
+	// reject implausible purchase dates (far future / outside submission window)
+	if purchaseOk {
+		if err := config.ActiveDatePolicy.Check(purchase.Date, purchase.Time, purchase.Offset); err != nil {
+			code, pointer := CodeOutOfRange, "/purchaseDate"
+			var policyErr *config.DatePolicyError
+			if errors.As(err, &policyErr) {
+				switch policyErr.Bound {
+				case config.BoundMaxFuture:
+					code = CodeDateInFuture
+				case config.BoundMaxAge:
+					code = CodeDateTooOld
+				}
+			}
+			if receipt.PurchasedAt != "" {
+				pointer = "/purchasedAt"
+			}
+			errs.add(pointer, code, err.Error(), err)
+		}
+	}
 
 	if len(receipt.Items) == 0 {
-		return errors.New(standardErrorPrefix + "items cannot be empty")
+		errs.add("/items", CodeRequired, "items cannot be empty", nil)
 	}
 
 	testItemsTotal := float64(0)
-	for _, item := range receipt.Items {
+	pricesOk := true
+	for i, item := range receipt.Items {
 		if item.ShortDescription == "" {
-			return errors.New(standardErrorPrefix + "item description cannot be empty")
+			errs.add(fmt.Sprintf("/items/%d/shortDescription", i), CodeRequired, "item description cannot be empty", nil)
 		}
+
+		pricePointer := fmt.Sprintf("/items/%d/price", i)
 		if item.Price == "" {
-			return errors.New(standardErrorPrefix + "item price cannot be empty")
+			errs.add(pricePointer, CodeRequired, "item price cannot be empty", nil)
+			pricesOk = false
+			continue
 		}
-		
-		// price less than or equal to 0 or an error...
+
+		// an error, or price less than or equal to 0 (NaN/Inf included)...
 		price, priceErr := strconv.ParseFloat(item.Price, 64)
-		if price <= 0 {
-			return errors.New(standardErrorPrefix + "item price must be greater than zero")
-		} else if priceErr != nil  {
-			return priceErr
+		if priceErr != nil || math.IsNaN(price) || math.IsInf(price, 0) {
+			errs.add(pricePointer, CodeInvalidFormat, "item price must be a number", priceErr)
+			pricesOk = false
+		} else if price <= 0 {
+			errs.add(pricePointer, CodeOutOfRange, "item price must be greater than zero", nil)
+			pricesOk = false
 		} else {
 			// add to testTotal to verify and check
 			testItemsTotal += price
@@ -79,74 +150,205 @@ func (receipt *Receipt) ValidateReceipt() error {
 
 	// convert to float + round...
 	receiptTotal, receiptErr := strconv.ParseFloat(receipt.Total, 64)
-	receiptTotal, testItemsTotal = config.RoundToNearestCent(receiptTotal), config.RoundToNearestCent(testItemsTotal)
-	
-	if receiptErr != nil {
-		return errors.New(standardErrorPrefix + "error on total price")
-	} else if receiptTotal != testItemsTotal {
-		return errors.New(standardErrorPrefix + "item calculatedTotal does not match Total price")
+	// big.Float rounding panics on NaN, so reject non-numbers first
+	if receipt.Total == "" {
+		errs.add("/total", CodeRequired, "total cannot be empty", nil)
+	} else if receiptErr != nil || math.IsNaN(receiptTotal) || math.IsInf(receiptTotal, 0) {
+		errs.add("/total", CodeInvalidFormat, "error on total price", receiptErr)
+	} else if receiptTotal < 0 {
+		errs.add("/total", CodeOutOfRange, "total cannot be negative", nil)
+	} else if pricesOk && len(receipt.Items) > 0 &&
+		config.RoundToNearestCent(receiptTotal) != config.RoundToNearestCent(testItemsTotal) {
+		errs.add("/total", CodeTotalMismatch, "item calculatedTotal does not match Total price", nil)
+	}
+
+	// strict-only checks; fields that already failed above are not reported twice
+	if profile == ProfileStrict {
+		receipt.validateSpecConformance(&errs)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validatePurchaseDateTime checks purchasedAt or purchaseDate + purchaseTime and
+// returns the normalized purchase moment when it is usable.
+func (receipt *Receipt) validatePurchaseDateTime(errs *ValidationErrors) (config.ParsedTimestamp, bool) {
+	if receipt.PurchasedAt != "" {
+		// purchasedAt replaces the split date/time fields, never both
+		if receipt.PurchaseDate != "" || receipt.PurchaseTime != "" {
+			errs.add("/purchasedAt", CodeConflict, "purchasedAt cannot be combined with purchaseDate or purchaseTime", nil)
+			return config.ParsedTimestamp{}, false
+		}
+		parsed, err := config.ValidateAndFormatTimestamp(receipt.PurchasedAt)
+		if err != nil {
+			errs.add("/purchasedAt", CodeInvalidFormat, err.Error(), err)
+			return config.ParsedTimestamp{}, false
+		}
+		return parsed, true
+	}
+
+	// Use consolidated date validation
+	formattedDate, dateErr := config.ValidateAndFormatDate(receipt.PurchaseDate)
+	if dateErr != nil {
+		errs.add("/purchaseDate", requiredOr(receipt.PurchaseDate, CodeInvalidFormat), dateErr.Error(), dateErr)
+	}
+
+	// Use consolidated time validation
+	formattedTime, timeErr := config.ValidateAndFormatTime(receipt.PurchaseTime)
+	if timeErr != nil {
+		errs.add("/purchaseTime", requiredOr(receipt.PurchaseTime, CodeInvalidFormat), timeErr.Error(), timeErr)
+	}
+
+	if dateErr != nil || timeErr != nil {
+		return config.ParsedTimestamp{}, false
+	}
+	return config.ParsedTimestamp{Date: formattedDate, Time: formattedTime}, true
+}
+
+// ApplyPurchasedAt normalizes a validated purchasedAt timestamp into
+// PurchaseDate and PurchaseTime, keeping the submitted offset.
+func (receipt *Receipt) ApplyPurchasedAt() error {
+	if receipt.PurchasedAt == "" {
+		// purchaseOffset is only ever derived from purchasedAt
+		receipt.PurchaseOffset = ""
+		return nil
+	}
+
+	parsed, err := config.ValidateAndFormatTimestamp(receipt.PurchasedAt)
+	if err != nil {
+		return fmt.Errorf("%s%v", standardErrorPrefix, err)
+	}
+	receipt.PurchaseDate = parsed.Date
+	receipt.PurchaseTime = parsed.Time
+	receipt.PurchaseOffset = parsed.Offset
+	return nil
+}
+
+// CaptureRaw records the submitted strings; call it before Normalize.
+func (receipt *Receipt) CaptureRaw() {
+	receipt.Raw = &RawInput{
+		PurchaseDate: receipt.PurchaseDate,
+		PurchaseTime: receipt.PurchaseTime,
+		PurchasedAt:  receipt.PurchasedAt,
+		Items:        append([]Item{}, receipt.Items...),
+		Total:        receipt.Total,
+	}
+}
+
+// Normalize rewrites a validated receipt into its canonical form:
+// purchasedAt split out, descriptions trimmed, date as YYYY-MM-DD and time as HH:MM.
+// Anything changed or guessed at along the way is recorded in Warnings.
+func (receipt *Receipt) Normalize() error {
+	receipt.Warnings = nil
+
+	// split purchasedAt (if given) into purchaseDate + purchaseTime
+	if err := receipt.ApplyPurchasedAt(); err != nil {
+		return err
+	}
+
+	// run trimming operation for itemShortDescriptions...
+	submitted := make([]string, len(receipt.Items))
+	for i, item := range receipt.Items {
+		submitted[i] = item.ShortDescription
+	}
+	receipt.CleanItemShortDescriptions()
+	for i, item := range receipt.Items {
+		if item.ShortDescription != submitted[i] {
+			receipt.addWarning(fmt.Sprintf("/items/%d/shortDescription", i), WarningDescriptionCollapsed,
+				fmt.Sprintf("description %q was trimmed to %q", submitted[i], item.ShortDescription))
+		}
+	}
+
+	// Format date using consolidated utility
+	parsedDate, err := config.ParseDate(receipt.PurchaseDate)
+	if err != nil {
+		return fmt.Errorf("Date formatting error: %v", err)
+	}
+	if parsedDate.Alternative != "" {
+		receipt.addWarning("/purchaseDate", WarningAmbiguousDate,
+			fmt.Sprintf("%s was read as %s (%s) but could also be %s", receipt.PurchaseDate, parsedDate.Value, parsedDate.Format, parsedDate.Alternative))
+	}
+	receipt.PurchaseDate = parsedDate.Value
+
+	// Format time using consolidated utility
+	parsedTime, err := config.ParseTime(receipt.PurchaseTime)
+	if err != nil {
+		return fmt.Errorf("Time formatting error: %v", err)
+	}
+	if parsedTime.SecondsDropped {
+		receipt.addWarning("/purchaseTime", WarningSecondsDropped,
+			fmt.Sprintf("seconds were dropped from %s; stored as %s", receipt.PurchaseTime, parsedTime.Value))
 	}
+	receipt.PurchaseTime = parsedTime.Value
 
+	if receipt.Raw != nil {
+		receipt.Raw.DateFormat, receipt.Raw.TimeFormat = parsedDate.Format, parsedTime.Format
+		if receipt.PurchasedAt != "" {
+			receipt.Raw.DateFormat, receipt.Raw.TimeFormat = "RFC 3339 timestamp", "RFC 3339 timestamp"
+		}
+	}
 	return nil
 }
 
+func (receipt *Receipt) addWarning(pointer, code, message string) {
+	receipt.Warnings = append(receipt.Warnings, Warning{Pointer: pointer, Code: code, Message: message})
+}
 
 func (receipt *Receipt) CalculatePoints() {
+	receipt.Breakdown = receipt.CalculatePointsBreakdown()
+	receipt.Points = receipt.Breakdown.Sum()
+}
+
+// PointsBreakdown is the points a receipt earns under each rule.
+type PointsBreakdown struct {
+	RetailerName     uint `json:"retailerName" xml:"retailerName"`
+	Total            uint `json:"total" xml:"total"`
+	ItemPairs        uint `json:"itemPairs" xml:"itemPairs"`
+	ItemDescriptions uint `json:"itemDescriptions" xml:"itemDescriptions"`
+	OddDay           uint `json:"oddDay" xml:"oddDay"`
+	Calendar         uint `json:"calendar" xml:"calendar"`
+	PurchaseTime     uint `json:"purchaseTime" xml:"purchaseTime"`
+}
+
+func (receipt *Receipt) CalculatePointsBreakdown() PointsBreakdown {
 	// Points Calculation
-	points := uint(0)
+	var breakdown PointsBreakdown
 
 	// add 1 pt for every alphaNumeric char in retailer name..
-	points += calculatePointsFromRetailerAlphaNumChar(receipt.Retailer)
+	breakdown.RetailerName = calculatePointsFromRetailerAlphaNumChar(receipt.Retailer)
 
 	// If the total is a multiple of 0.25, add 25 pts.
-	points += calculatePointsFromTotal(receipt.Total)
+	breakdown.Total = calculatePointsFromTotal(receipt.Total)
 
 	// add 5 points for every TWO items in the receipt.
 	// 3/2 -> 1 (discards .5)
-	points += calculatePointsForEveryTwoItems(receipt.Items)
+	breakdown.ItemPairs = calculatePointsForEveryTwoItems(receipt.Items)
 	//(uint(((len(receipt.Items) / 2) * 5)))
 
 	// go through items w/ pre-trimmed descriptions.
-	points += calculatePointsFromItemPriceAndDesc(receipt.Items)
+	breakdown.ItemDescriptions = calculatePointsFromItemPriceAndDesc(receipt.Items)
 
 	/*
 		Processing date + time.
 	*/
 	// Parse the purchaseDate and check if the day is odd or even.
-	points += calculatePointsFromPurchaseDate(receipt.PurchaseDate)
+	breakdown.OddDay = calculatePointsFromPurchaseDate(receipt.PurchaseDate)
+
+	// weekend/holiday/day-of-month rules from the loaded calendar.
+	breakdown.Calendar = calculatePointsFromCalendar(receipt.PurchaseDate)
 
 	// Parse the purchaseTime and check if between 
 	// after startTime && before endTime.
-	points += calculatePointsFromPurchaseTime(receipt.PurchaseTime)
+	breakdown.PurchaseTime = calculatePointsFromPurchaseTime(receipt.PurchaseTime)
 
-	receipt.Points = points
+	return breakdown
 }
 
-func AddReceipt(receipt Receipt) error {
-	receiptsMux.Lock()
-	receipts[receipt.ID] = receipt
-	receiptsMux.Unlock()
-
-	return nil
-}
-func GetReceiptById(id string) (Receipt, bool) {
-	receiptsMux.Lock()
-	receipt, exists := receipts[id]
-	receiptsMux.Unlock()
-	return receipt, exists
-}
-func GetAllReceipts() []Receipt {
-	receiptsMux.Lock()
-	defer receiptsMux.Unlock()
-	receiptsList := make([]Receipt, 0, len(receipts))
-	for _, receipt := range receipts {
-		receiptsList = append(receiptsList, receipt)
-	}
-	return receiptsList
-}
-
-func ClearReceipts() {
-    receipts = make(map[string]Receipt)
+func (b PointsBreakdown) Sum() uint {
+	return b.RetailerName + b.Total + b.ItemPairs + b.ItemDescriptions + b.OddDay + b.Calendar + b.PurchaseTime
 }
 
 // Helper Functions:
@@ -214,6 +416,15 @@ func calculatePointsFromPurchaseDate(purchaseDate string) uint {
     return points
 }
 
+func calculatePointsFromCalendar(purchaseDate string) uint {
+    points := uint(0)
+    // Date is already validated and in ISO format
+    for _, match := range config.ActiveCalendar.Match(purchaseDate) {
+        points += match.Points
+    }
+    return points
+}
+
 func calculatePointsFromPurchaseTime(purchaseTime string) uint {
     points := uint(0)
     inRange, err := config.IsTimeInRange(purchaseTime, "14:00", "16:00")
@@ -2,8 +2,19 @@ package model
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"receipt-processor-challenge/config"
 
 	"github.com/google/uuid"
 )
@@ -27,6 +38,173 @@ func TestValidateReceipt(t *testing.T) {
 	}
 }
 
+func TestValidateReceiptWithProfile(t *testing.T) {
+	for _, testCase := range ValidationProfileTestCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			profiles := map[ValidationProfile]bool{
+				ProfileLenient: testCase.LenientValid,
+				ProfileStrict:  testCase.StrictValid,
+			}
+			for profile, expected := range profiles {
+				var receipt Receipt
+				if err := json.Unmarshal([]byte(testCase.JsonData), &receipt); err != nil {
+					t.Fatalf("Failed to unmarshal JSON: %v", err)
+				}
+
+				err := receipt.ValidateReceiptWithProfile(profile)
+				if isValid := err == nil; isValid != expected {
+					t.Errorf("Profile %s: expected isValid to be %v, got %v (%v)", profile, expected, isValid, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateReceiptCollectsAllErrors(t *testing.T) {
+	receipt := Receipt{
+		Retailer:     "",
+		PurchaseDate: "2022-13-01",
+		PurchaseTime: "13:01",
+		Items: []Item{
+			{ShortDescription: "Gum", Price: "1.00"},
+			{ShortDescription: "", Price: "-2.00"},
+			{ShortDescription: "Soda", Price: "abc"},
+		},
+		Total: "3.00",
+	}
+
+	err := receipt.ValidateReceipt()
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+
+	expected := []string{
+		"/retailer:" + CodeRequired,
+		"/purchaseDate:" + CodeInvalidFormat,
+		"/items/1/shortDescription:" + CodeRequired,
+		"/items/1/price:" + CodeOutOfRange,
+		"/items/2/price:" + CodeInvalidFormat,
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Pointer+":"+e.Code)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected errors %v, got %v", expected, got)
+	}
+}
+
+func TestApplyPurchasedAt(t *testing.T) {
+	receipt := Receipt{PurchasedAt: "2024-02-07T13:45:00-05:00"}
+	if err := receipt.ApplyPurchasedAt(); err != nil {
+		t.Fatalf("ApplyPurchasedAt() error = %v", err)
+	}
+
+	if receipt.PurchaseDate != "2024-02-07" || receipt.PurchaseTime != "13:45" {
+		t.Errorf("Expected 2024-02-07 13:45, got %s %s", receipt.PurchaseDate, receipt.PurchaseTime)
+	}
+	if receipt.PurchaseOffset != "-05:00" {
+		t.Errorf("Expected offset -05:00, got %s", receipt.PurchaseOffset)
+	}
+	if receipt.PurchasedAt != "2024-02-07T13:45:00-05:00" {
+		t.Errorf("Expected original purchasedAt to be kept, got %s", receipt.PurchasedAt)
+	}
+}
+
+func TestValidateReceiptDatePolicy(t *testing.T) {
+	defer func(saved config.DatePolicy) { config.ActiveDatePolicy = saved }(config.ActiveDatePolicy)
+	config.ActiveDatePolicy = config.DatePolicy{
+		MaxFuture: 15 * time.Minute,
+		MaxAge:    30 * 24 * time.Hour,
+		Now: func() time.Time {
+			return time.Date(2024, 2, 7, 12, 0, 0, 0, time.UTC)
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		receipt       Receipt
+		expectedBound string
+	}{
+		{"Within window", Receipt{PurchaseDate: "2024-02-01", PurchaseTime: "10:00"}, ""},
+		{"Later today in an eastern zone", Receipt{PurchaseDate: "2024-02-07", PurchaseTime: "23:30"}, ""},
+		{"Far future", Receipt{PurchaseDate: "2099-01-01", PurchaseTime: "10:00"}, config.BoundMaxFuture},
+		{"Future with known offset", Receipt{PurchasedAt: "2024-02-07T13:00:00Z"}, config.BoundMaxFuture},
+		{"Older than submission window", Receipt{PurchaseDate: "1901-01-01", PurchaseTime: "10:00"}, config.BoundMaxAge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := tc.receipt
+			receipt.Retailer = "Target"
+			receipt.Total = "1.00"
+			receipt.Items = []Item{{ShortDescription: "Gum", Price: "1.00"}}
+
+			err := receipt.ValidateReceipt()
+			var policyErr *config.DatePolicyError
+			if tc.expectedBound == "" {
+				if err != nil {
+					t.Errorf("Expected receipt to be valid, got %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Expected a DatePolicyError, got %v", err)
+			}
+			if policyErr.Bound != tc.expectedBound {
+				t.Errorf("Expected bound %s, got %s", tc.expectedBound, policyErr.Bound)
+			}
+		})
+	}
+}
+
+func TestNormalizeWarnings(t *testing.T) {
+	testCases := []struct {
+		name     string
+		receipt  Receipt
+		expected []string
+	}{
+		{
+			name:     "Clean input",
+			receipt:  Receipt{PurchaseDate: "2024-02-07", PurchaseTime: "13:45", Items: []Item{{ShortDescription: "Gum"}}},
+			expected: nil,
+		},
+		{
+			name:     "Ambiguous US/UK date",
+			receipt:  Receipt{PurchaseDate: "02/07/2024", PurchaseTime: "13:45", Items: []Item{{ShortDescription: "Gum"}}},
+			expected: []string{"/purchaseDate:" + WarningAmbiguousDate},
+		},
+		{
+			name:     "Same date either way",
+			receipt:  Receipt{PurchaseDate: "05/05/2024", PurchaseTime: "13:45", Items: []Item{{ShortDescription: "Gum"}}},
+			expected: nil,
+		},
+		{
+			name:     "Seconds and collapsed description",
+			receipt:  Receipt{PurchaseDate: "2024-02-07", PurchaseTime: "1:45:30 PM", Items: []Item{{ShortDescription: "Gum"}, {ShortDescription: " Big   Gum "}}},
+			expected: []string{"/items/1/shortDescription:" + WarningDescriptionCollapsed, "/purchaseTime:" + WarningSecondsDropped},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := tc.receipt
+			if err := receipt.Normalize(); err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+
+			var got []string
+			for _, warning := range receipt.Warnings {
+				got = append(got, warning.Pointer+":"+warning.Code)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected warnings %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
 func TestGenerateID(t *testing.T) {
 	receipt := Receipt{}
 	receipt.GenerateUniqueID()
@@ -127,6 +305,44 @@ func TestCleanItemShortDescription(t *testing.T) {
 	}
 }
 
+func TestCalculatePointsFromCalendar(t *testing.T) {
+	defer func(saved config.Calendar) { config.ActiveCalendar = saved }(config.ActiveCalendar)
+	config.ActiveCalendar = config.Calendar{
+		WeekendPoints: 5,
+		Holidays: []config.Holiday{
+			{Name: "New Year's Day", Month: 1, Day: 1, Points: 10},
+			{Name: "Thanksgiving", Month: 11, Weekday: "Thursday", Week: 4, Points: 15},
+			{Name: "Memorial Day", Month: 5, Weekday: "Monday", Week: -1, Points: 10},
+		},
+		DayRanges: []config.DayRange{
+			{Name: "Month start", From: 1, To: 3, Points: 2},
+		},
+	}
+	if err := config.ActiveCalendar.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	testCases := []struct {
+		date     string
+		expected uint
+	}{
+		{"2022-01-01", 17},  // Saturday + New Year's Day + month start
+		{"2024-11-28", 15},  // Thanksgiving (4th Thursday)
+		{"2024-11-21", 0},   // 3rd Thursday
+		{"2024-05-27", 10},  // last Monday of May
+		{"2024-05-20", 0},   // not the last Monday
+		{"2024-02-10", 5},   // Saturday
+		{"2024-02-07", 0},   // plain Wednesday
+	}
+
+	for _, tc := range testCases {
+		result := calculatePointsFromCalendar(tc.date)
+		if result != tc.expected {
+			t.Errorf("For date %s, expected %d, got %d", tc.date, tc.expected, result)
+		}
+	}
+}
+
 func TestCalculatePointsFromRetailerAlphaNumChar(t *testing.T) {
 	testCases := []struct {
 		retailer string
@@ -175,8 +391,18 @@ func TestCalculatePointsFromTotal(t *testing.T) {
 
 
 func TestReceiptManagement(t *testing.T) {
-    // Clear the receipts map before testing
-    receipts = make(map[string]Receipt)
+    stores := map[string]func() ReceiptStore{
+        "MemoryStore":  func() ReceiptStore { return NewMemoryStore() },
+        "ShardedStore": func() ReceiptStore { return NewShardedStore(0) },
+    }
+    for name, newStore := range stores {
+        t.Run(name, func(t *testing.T) { testReceiptStore(t, newStore) })
+    }
+}
+
+// testReceiptStore checks the ReceiptStore contract every implementation shares
+func testReceiptStore(t *testing.T, newStore func() ReceiptStore) {
+    store := newStore()
 
     // Create test receipts
     receipt1 := Receipt{
@@ -190,40 +416,40 @@ func TestReceiptManagement(t *testing.T) {
         Items:    []Item{{ShortDescription: "Item 2", Price: "20.00"}},
     }
 
-    // Test AddReceipt
-    t.Run("AddReceipt", func(t *testing.T) {
-        err := AddReceipt(receipt1)
+    // Test Put
+    t.Run("Put", func(t *testing.T) {
+        err := store.Put(receipt1)
         if err != nil {
-            t.Errorf("AddReceipt() error = %v", err)
+            t.Errorf("Put() error = %v", err)
         }
-        err = AddReceipt(receipt2)
+        err = store.Put(receipt2)
         if err != nil {
-            t.Errorf("AddReceipt() error = %v", err)
+            t.Errorf("Put() error = %v", err)
         }
     })
 
-    // Test GetReceiptById
-    t.Run("GetReceiptById", func(t *testing.T) {
-        got, exists := GetReceiptById("test-id-1")
+    // Test Get
+    t.Run("Get", func(t *testing.T) {
+        got, exists := store.Get("test-id-1")
         if !exists {
-            t.Error("GetReceiptById() exists = false, want true")
+            t.Error("Get() exists = false, want true")
         }
         if !reflect.DeepEqual(got, receipt1) {
-            t.Errorf("GetReceiptById() got = %v, want %v", got, receipt1)
+            t.Errorf("Get() got = %v, want %v", got, receipt1)
         }
 
         // Test non-existent receipt
-        _, exists = GetReceiptById("non-existent")
+        _, exists = store.Get("non-existent")
         if exists {
-            t.Error("GetReceiptById() exists = true, want false")
+            t.Error("Get() exists = true, want false")
         }
     })
 
-    // Test GetAllReceipts
-    t.Run("GetAllReceipts", func(t *testing.T) {
-        got := GetAllReceipts()
+    // Test List
+    t.Run("List", func(t *testing.T) {
+        got := store.List()
         if len(got) != 2 {
-            t.Errorf("GetAllReceipts() returned %d receipts, want 2", len(got))
+            t.Errorf("List() returned %d receipts, want 2", len(got))
         }
         // Check if both receipts are present
         found1, found2 := false, false
@@ -236,7 +462,776 @@ func TestReceiptManagement(t *testing.T) {
             }
         }
         if !found1 || !found2 {
-            t.Error("GetAllReceipts() missing expected receipts")
+            t.Error("List() missing expected receipts")
+        }
+    })
+
+    // Test Iterate
+    t.Run("Iterate", func(t *testing.T) {
+        visited := 0
+        store.Iterate(func(Receipt) bool {
+            visited++
+            return false
+        })
+        if visited != 1 {
+            t.Errorf("Iterate() visited %d receipts after fn returned false, want 1", visited)
         }
     })
-}
\ No newline at end of file
+
+    // Test Delete
+    t.Run("Delete", func(t *testing.T) {
+        if existed, err := store.Delete("test-id-1"); !existed || err != nil {
+            t.Errorf("Delete() = %v, %v, want true, nil", existed, err)
+        }
+        if existed, _ := store.Delete("test-id-1"); existed {
+            t.Error("Delete() of a deleted receipt = true, want false")
+        }
+        if _, exists := store.Get("test-id-1"); exists {
+            t.Error("Get() found a deleted receipt")
+        }
+    })
+
+    // Stores are isolated from each other
+    t.Run("Isolation", func(t *testing.T) {
+        if got := len(newStore().List()); got != 0 {
+            t.Errorf("a new store has %d receipts, want 0", got)
+        }
+    })
+}
+
+func TestShardedStoreConcurrency(t *testing.T) {
+	store := NewShardedStore(4)
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				id := fmt.Sprintf("%d-%d", worker, i)
+				store.Put(Receipt{ID: id})
+				if _, exists := store.Get(id); !exists {
+					t.Errorf("Get(%q) missed a receipt just stored", id)
+				}
+				if i%2 == 0 {
+					store.Delete(id)
+				}
+				store.List()
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	if got := store.Len(); got != 8*100 {
+		t.Errorf("Len() = %d, want %d", got, 8*100)
+	}
+	visited := 0
+	store.Iterate(func(Receipt) bool { visited++; return true })
+	if visited != 8*100 {
+		t.Errorf("Iterate() visited %d receipts, want %d", visited, 8*100)
+	}
+}
+
+func TestReceiptQuery(t *testing.T) {
+	receipts := []Receipt{
+		{ID: "a", Retailer: "Target", PurchaseDate: "2024-02-01", PurchaseTime: "10:00", Total: "5.00", Points: 30},
+		{ID: "b", Retailer: "M&M  Corner Market", PurchaseDate: "2024-02-03", PurchaseTime: "10:00", Total: "9.00", Points: 109},
+		{ID: "c", Retailer: "Target", PurchaseDate: "2024-02-02", PurchaseTime: "10:00", Total: "35.35", Points: 28},
+		{ID: "d", Retailer: "target", PurchaseDate: "2024-02-02", PurchaseTime: "10:00", Total: "1.25", Points: 30},
+	}
+	minTotal, maxPoints := 2.0, uint(100)
+
+	testCases := []struct {
+		name     string
+		query    ReceiptQuery
+		expected []string
+	}{
+		{"Default purchase date order", ReceiptQuery{}, []string{"a", "c", "d", "b"}},
+		{"Points descending, ties by ID", ReceiptQuery{Sort: "-points"}, []string{"b", "a", "d", "c"}},
+		{"Total ascending", ReceiptQuery{Sort: "total"}, []string{"d", "a", "b", "c"}},
+		{"Normalized retailer", ReceiptQuery{Retailer: "m&m corner market"}, []string{"b"}},
+		{"Date range", ReceiptQuery{From: "2024-02-02", To: "2024-02-02"}, []string{"c", "d"}},
+		{"Min total and max points", ReceiptQuery{MinTotal: &minTotal, MaxPoints: &maxPoints}, []string{"a", "c"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := tc.query.Run(receipts)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			var got []string
+			for _, receipt := range page.Receipts {
+				got = append(got, receipt.ID)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	t.Run("Cursor from another sort is rejected", func(t *testing.T) {
+		page, _ := ReceiptQuery{Limit: 1}.Run(receipts)
+		if _, err := (ReceiptQuery{Sort: "points", Cursor: page.Next}).Run(receipts); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected ErrInvalidCursor, got %v", err)
+		}
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir, FileStoreOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+
+	quantity := Receipt{ID: "v2", Retailer: "Target", Currency: "EUR", MemberID: "m-1",
+		Items: []Item{{ShortDescription: "Dew", Price: "6.00", Quantity: 3}}, Points: 42}
+	for _, receipt := range []Receipt{{ID: "a", Retailer: "A"}, {ID: "b", Retailer: "B"}, quantity} {
+		if err := store.Put(receipt); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	if existed, err := store.Delete("a"); !existed || err != nil {
+		t.Fatalf("Delete() = %v, %v, want true, nil", existed, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := store.Put(Receipt{ID: "c"}); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("Put() after Close() error = %v, want ErrStoreClosed", err)
+	}
+
+	reopened, err := OpenFileStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	if stats := reopened.Recovery(); stats.ReplayedRecords != 4 || stats.TruncatedBytes != 0 {
+		t.Errorf("Recovery() = %+v, want 4 replayed records and nothing truncated", stats)
+	}
+	if _, exists := reopened.Get("a"); exists {
+		t.Error("a deleted receipt came back after a restart")
+	}
+	// fields JSON leaves out must survive too
+	if got, _ := reopened.Get("v2"); !reflect.DeepEqual(got, quantity) {
+		t.Errorf("Get() = %+v, want %+v", got, quantity)
+	}
+
+	t.Run("Snapshot", func(t *testing.T) {
+		if err := reopened.Snapshot(); err != nil {
+			t.Fatalf("Snapshot() error = %v", err)
+		}
+		if info, _ := os.Stat(filepath.Join(dir, walFileName)); info.Size() != 0 {
+			t.Errorf("the log has %d bytes after a snapshot, want 0", info.Size())
+		}
+		reopened.Put(Receipt{ID: "d"})
+		reopened.Close()
+
+		store, err := OpenFileStore(dir, FileStoreOptions{})
+		if err != nil {
+			t.Fatalf("OpenFileStore() error = %v", err)
+		}
+		defer store.Close()
+		if stats := store.Recovery(); stats.SnapshotReceipts != 2 || stats.ReplayedRecords != 1 {
+			t.Errorf("Recovery() = %+v, want 2 snapshot receipts and 1 replayed record", stats)
+		}
+		if got := len(store.List()); got != 3 {
+			t.Errorf("List() returned %d receipts, want 3", got)
+		}
+	})
+
+	t.Run("Automatic snapshots", func(t *testing.T) {
+		dir := t.TempDir()
+		store, _ := OpenFileStore(dir, FileStoreOptions{Sync: SyncNever, SnapshotEvery: 2})
+		for _, id := range []string{"a", "b", "c"} {
+			store.Put(Receipt{ID: id})
+		}
+		store.Close()
+
+		store, _ = OpenFileStore(dir, FileStoreOptions{})
+		defer store.Close()
+		if stats := store.Recovery(); stats.SnapshotReceipts != 2 || stats.ReplayedRecords != 1 {
+			t.Errorf("Recovery() = %+v, want 2 snapshot receipts and 1 replayed record", stats)
+		}
+	})
+
+	t.Run("Concurrent deletes", func(t *testing.T) {
+		dir := t.TempDir()
+		store, _ := OpenFileStore(dir, FileStoreOptions{Sync: SyncNever})
+		store.Put(Receipt{ID: "a"})
+		var wg sync.WaitGroup
+		var deleted atomic.Int32
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if existed, _ := store.Delete("a"); existed {
+					deleted.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		store.Close()
+		if deleted.Load() != 1 {
+			t.Errorf("Expected exactly one Delete to report the receipt existed, got %d", deleted.Load())
+		}
+
+		store, _ = OpenFileStore(dir, FileStoreOptions{})
+		defer store.Close()
+		if stats := store.Recovery(); stats.ReplayedRecords != 2 {
+			t.Errorf("Recovery() = %+v, want the put and a single delete replayed", stats)
+		}
+	})
+
+	t.Run("Failed snapshots back off", func(t *testing.T) {
+		dir := t.TempDir()
+		store, _ := OpenFileStore(dir, FileStoreOptions{Sync: SyncNever, SnapshotEvery: 1})
+		defer store.Close()
+		// a directory in the way makes creating the snapshot fail
+		blocker := filepath.Join(dir, snapshotFileName+".tmp")
+		os.Mkdir(blocker, 0o755)
+		if err := store.Put(Receipt{ID: "a"}); err != nil {
+			t.Fatalf("Put() error = %v, want the write to succeed without its snapshot", err)
+		}
+		os.Remove(blocker)
+
+		walSize := func() int64 {
+			info, _ := os.Stat(filepath.Join(dir, walFileName))
+			return info.Size()
+		}
+		store.Put(Receipt{ID: "b"})
+		if walSize() == 0 {
+			t.Error("Expected no snapshot to be tried again before the backoff passed")
+		}
+		store.snapshotRetryAt = time.Time{}
+		store.Put(Receipt{ID: "c"})
+		if walSize() != 0 {
+			t.Error("Expected the snapshot to be retried after the backoff")
+		}
+	})
+
+	t.Run("Failed writes are rolled back", func(t *testing.T) {
+		dir := t.TempDir()
+		store, _ := OpenFileStore(dir, FileStoreOptions{Sync: SyncAlways})
+		store.Put(Receipt{ID: "a"})
+		file := store.wal
+		for _, fail := range []*failingWAL{{walFile: file, shortWrite: true}, {walFile: file, failSync: true}} {
+			store.wal = fail
+			if err := store.Put(Receipt{ID: "b"}); err == nil {
+				t.Fatal("Put() error = nil, want the write error")
+			}
+			if _, exists := store.Get("b"); exists {
+				t.Error("a failed Put() was applied in memory")
+			}
+		}
+		store.wal = file
+		store.Put(Receipt{ID: "c"})
+		store.Close()
+
+		store, _ = OpenFileStore(dir, FileStoreOptions{})
+		defer store.Close()
+		if stats := store.Recovery(); stats.ReplayedRecords != 2 || stats.TruncatedBytes != 0 {
+			t.Errorf("Recovery() = %+v, want a and c replayed and nothing truncated", stats)
+		}
+		if _, exists := store.Get("b"); exists {
+			t.Error("a failed Put() came back after a restart")
+		}
+	})
+
+	if _, err := OpenFileStore(t.TempDir(), FileStoreOptions{Sync: "sometimes"}); err == nil {
+		t.Error("Expected an error for an unknown sync mode")
+	}
+}
+
+func TestFileStoreRecovery(t *testing.T) {
+	// write three receipts, noting where each record ends
+	source := t.TempDir()
+	store, err := OpenFileStore(source, FileStoreOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	var ends []int64
+	for _, id := range []string{"a", "b", "c"} {
+		store.Put(Receipt{ID: id, Retailer: "Retailer " + id})
+		info, _ := os.Stat(filepath.Join(source, walFileName))
+		ends = append(ends, info.Size())
+	}
+	store.Close()
+	log, _ := os.ReadFile(filepath.Join(source, walFileName))
+
+	corruptLast := append([]byte(nil), log...)
+	corruptLast[len(corruptLast)-1] ^= 0xff
+
+	testCases := []struct {
+		name string
+		log  []byte
+	}{
+		{"Torn header", log[:ends[1]+recordHeaderSize/2]},
+		{"Torn payload", log[:ends[1]+(ends[2]-ends[1])/2]},
+		{"Last byte missing", log[:ends[2]-1]},
+		{"Checksum mismatch", corruptLast},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, walFileName)
+			if err := os.WriteFile(path, tc.log, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := OpenFileStore(dir, FileStoreOptions{})
+			if err != nil {
+				t.Fatalf("OpenFileStore() error = %v", err)
+			}
+			stats := store.Recovery()
+			if stats.ReplayedRecords != 2 || stats.TruncatedBytes != int64(len(tc.log))-ends[1] {
+				t.Errorf("Recovery() = %+v, want 2 replayed records and %d bytes truncated", stats, int64(len(tc.log))-ends[1])
+			}
+			if _, exists := store.Get("c"); exists {
+				t.Error("the damaged record was applied")
+			}
+
+			// writes after recovery land after the last intact record, not behind the damage
+			if err := store.Put(Receipt{ID: "d"}); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			store.Close()
+			store, err = OpenFileStore(dir, FileStoreOptions{})
+			if err != nil {
+				t.Fatalf("OpenFileStore() error = %v", err)
+			}
+			defer store.Close()
+			if stats := store.Recovery(); stats.ReplayedRecords != 3 || stats.TruncatedBytes != 0 {
+				t.Errorf("second Recovery() = %+v, want 3 replayed records and nothing truncated", stats)
+			}
+			if _, exists := store.Get("d"); !exists {
+				t.Error("a receipt written after recovery was lost")
+			}
+		})
+	}
+}
+
+func TestBoundedStore(t *testing.T) {
+	put := func(s *BoundedStore, ids ...string) {
+		t.Helper()
+		for _, id := range ids {
+			if err := s.Put(Receipt{ID: id, Retailer: "Target"}); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+		}
+	}
+	ids := func(s *BoundedStore) []string {
+		var got []string
+		for _, receipt := range s.List() {
+			got = append(got, receipt.ID)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	t.Run("LRU", func(t *testing.T) {
+		store := NewBoundedStore(NewMemoryStore(), BoundedStoreOptions{MaxReceipts: 2, Policy: EvictLRU})
+		put(store, "a", "b")
+		store.Get("a") // b is now the least recently used
+		put(store, "c")
+		if got := ids(store); !reflect.DeepEqual(got, []string{"a", "c"}) {
+			t.Errorf("Expected [a c], got %v", got)
+		}
+		if stats := store.Stats(); stats.Evicted[EvictedMaxReceipts] != 1 || stats.Receipts != 2 {
+			t.Errorf("Stats() = %+v", stats)
+		}
+	})
+
+	t.Run("Oldest first", func(t *testing.T) {
+		store := NewBoundedStore(NewMemoryStore(), BoundedStoreOptions{MaxReceipts: 2, Policy: EvictOldest})
+		put(store, "a", "b")
+		store.Get("a") // reads do not matter
+		put(store, "c")
+		if got := ids(store); !reflect.DeepEqual(got, []string{"b", "c"}) {
+			t.Errorf("Expected [b c], got %v", got)
+		}
+	})
+
+	t.Run("Memory budget", func(t *testing.T) {
+		size := receiptSize(Receipt{ID: "a", Retailer: "Target"})
+		inner := NewMemoryStore()
+		store := NewBoundedStore(inner, BoundedStoreOptions{MaxBytes: 2*size + size/2})
+		put(store, "a", "b", "c")
+		if got := ids(store); !reflect.DeepEqual(got, []string{"b", "c"}) {
+			t.Errorf("Expected [b c], got %v", got)
+		}
+		if got := len(inner.List()); got != 2 {
+			t.Errorf("Expected the evicted receipt removed from the inner store, it has %d", got)
+		}
+		if stats := store.Stats(); stats.Bytes != 2*size || stats.Evicted[EvictedMaxBytes] != 1 {
+			t.Errorf("Stats() = %+v, want %d bytes and 1 eviction", stats, 2*size)
+		}
+		huge := Receipt{ID: "huge", Retailer: strings.Repeat("x", int(3*size))}
+		if err := store.Put(huge); !errors.Is(err, ErrReceiptTooLarge) {
+			t.Errorf("Put() error = %v, want ErrReceiptTooLarge", err)
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		clock := time.Date(2024, 2, 7, 12, 0, 0, 0, time.UTC)
+		store := NewBoundedStore(NewMemoryStore(), BoundedStoreOptions{TTL: time.Hour})
+		defer store.Close()
+		store.now = func() time.Time { return clock }
+
+		put(store, "a")
+		clock = clock.Add(30 * time.Minute)
+		put(store, "b")
+		clock = clock.Add(30 * time.Minute)
+
+		if _, exists := store.Get("a"); exists {
+			t.Error("Expected an expired receipt not to be found")
+		}
+		if got := ids(store); !reflect.DeepEqual(got, []string{"b"}) {
+			t.Errorf("Expected [b], got %v", got)
+		}
+		clock = clock.Add(30 * time.Minute)
+		visited := 0
+		store.Iterate(func(Receipt) bool { visited++; return true })
+		if visited != 0 {
+			t.Errorf("Iterate() visited %d expired receipts", visited)
+		}
+		store.Sweep()
+		if stats := store.Stats(); stats.Receipts != 0 || stats.Evicted[EvictedTTL] != 2 {
+			t.Errorf("Stats() = %+v, want nothing left and 2 expired", stats)
+		}
+	})
+
+	t.Run("Existing receipts", func(t *testing.T) {
+		inner := NewMemoryStore()
+		for _, id := range []string{"a", "b", "c"} {
+			inner.Put(Receipt{ID: id})
+		}
+		store := NewBoundedStore(inner, BoundedStoreOptions{MaxReceipts: 2})
+		if got := len(store.List()); got != 2 {
+			t.Errorf("Expected the store trimmed to 2 receipts, got %d", got)
+		}
+	})
+}
+
+func TestIndexedStore(t *testing.T) {
+	points := func(n uint) *uint { return &n }
+	store := NewIndexedStore(NewShardedStore(0))
+	for i := 0; i < 60; i++ {
+		store.Put(Receipt{
+			ID:           fmt.Sprintf("r%02d", i),
+			Retailer:     []string{"Target", "M&M  Corner Market", "Walgreens"}[i%3],
+			PurchaseDate: fmt.Sprintf("2024-02-%02d", 1+i%20),
+			PurchaseTime: "13:01",
+			Total:        "10.00",
+			Points:       uint(i),
+		})
+	}
+
+	testCases := []struct {
+		name  string
+		query ReceiptQuery
+		index string
+	}{
+		{"No filter scans", ReceiptQuery{}, ""},
+		{"Total has no index", ReceiptQuery{MinTotal: new(float64)}, ""},
+		{"Retailer", ReceiptQuery{Retailer: "m&m corner market"}, IndexRetailer},
+		{"Date range", ReceiptQuery{From: "2024-02-03", To: "2024-02-04"}, IndexPurchaseDate},
+		{"Open-ended date", ReceiptQuery{To: "2024-02-01"}, IndexPurchaseDate},
+		{"Points range", ReceiptQuery{MinPoints: points(10), MaxPoints: points(12)}, IndexPoints},
+		{"Most selective wins", ReceiptQuery{Retailer: "Target", MinPoints: points(55)}, IndexPoints},
+		{"Unknown retailer", ReceiptQuery{Retailer: "Costco", From: "2024-01-01"}, IndexRetailer},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, index := store.Candidates(tc.query); index != tc.index {
+				t.Errorf("Expected index %q, got %q", tc.index, index)
+			}
+			// an index must never change the answer
+			got, err := QueryReceipts(store, tc.query)
+			if err != nil {
+				t.Fatalf("QueryReceipts() error = %v", err)
+			}
+			expected, _ := tc.query.Run(store.List())
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("Expected %d receipts from a scan, the index gave %d", len(expected.Receipts), len(got.Receipts))
+			}
+		})
+	}
+
+	t.Run("Updates and deletes", func(t *testing.T) {
+		store.Put(Receipt{ID: "r00", Retailer: "Costco", PurchaseDate: "2023-12-31", Points: 1000})
+		if candidates, _ := store.Candidates(ReceiptQuery{Retailer: "Target"}); len(candidates) != 19 {
+			t.Errorf("Expected the updated receipt gone from its old retailer, got %d candidates", len(candidates))
+		}
+		if candidates, _ := store.Candidates(ReceiptQuery{To: "2023-12-31"}); len(candidates) != 1 || candidates[0].ID != "r00" {
+			t.Errorf("Expected the updated receipt under its new date, got %v", candidates)
+		}
+		store.Delete("r00")
+		for _, q := range []ReceiptQuery{{Retailer: "Costco"}, {To: "2023-12-31"}, {MinPoints: points(1000)}} {
+			if candidates, _ := store.Candidates(q); len(candidates) != 0 {
+				t.Errorf("Expected a deleted receipt out of every index, %+v found %d", q, len(candidates))
+			}
+		}
+	})
+
+	t.Run("Under a bounded store", func(t *testing.T) {
+		bounded := NewBoundedStore(NewIndexedStore(NewMemoryStore()), BoundedStoreOptions{MaxReceipts: 1})
+		bounded.Put(Receipt{ID: "a", Retailer: "Target"})
+		bounded.Put(Receipt{ID: "b", Retailer: "Target"})
+		candidates, index := bounded.Candidates(ReceiptQuery{Retailer: "Target"})
+		if index != IndexRetailer || len(candidates) != 1 || candidates[0].ID != "b" {
+			t.Errorf("Expected only the surviving receipt from the index, got %q %v", index, candidates)
+		}
+	})
+}
+
+func TestFindDuplicate(t *testing.T) {
+	original := Receipt{ID: "original", Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "13:01", Total: "9.00",
+		Items: []Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}, {ShortDescription: "Emils Cheese Pizza", Price: "2.51"}}}
+
+	testCases := []struct {
+		name   string
+		change func(r *Receipt)
+		found  bool
+		exact  bool
+	}{
+		{"Identical", func(r *Receipt) {}, true, true},
+		{"Spacing, case, item order and amount format", func(r *Receipt) {
+			r.Retailer, r.Total = "  TARGET ", "9"
+			r.Items = []Item{{ShortDescription: "emils  cheese pizza", Price: "2.510"}, {ShortDescription: "Mountain Dew 12PK", Price: "6.49"}}
+		}, true, true},
+		{"One minute later", func(r *Receipt) { r.PurchaseTime = "13:02" }, true, false},
+		{"Same time the previous day", func(r *Receipt) { r.PurchaseDate = "2021-12-31" }, false, false},
+		{"Two minutes later", func(r *Receipt) { r.PurchaseTime = "13:03" }, false, false},
+		{"Different total", func(r *Receipt) { r.Total = "9.01" }, false, false},
+		{"Different quantity", func(r *Receipt) { r.Items = []Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49", Quantity: 2}, r.Items[1]} }, false, false},
+	}
+
+	stores := map[string]ReceiptStore{"Scan": NewMemoryStore(), "Index": NewIndexedStore(NewMemoryStore())}
+	for name, store := range stores {
+		store.Put(original)
+		for _, tc := range testCases {
+			t.Run(name+"/"+tc.name, func(t *testing.T) {
+				receipt := original
+				receipt.ID = "new"
+				receipt.Items = append([]Item(nil), original.Items...)
+				tc.change(&receipt)
+
+				duplicate, found := FindDuplicate(store, receipt)
+				if found != tc.found || duplicate.Exact != tc.exact {
+					t.Fatalf("Expected found=%v exact=%v, got %v %+v", tc.found, tc.exact, found, duplicate)
+				}
+				if found && duplicate.Original.ID != "original" {
+					t.Errorf("Expected the original, got %s", duplicate.Original.ID)
+				}
+			})
+		}
+	}
+
+	t.Run("Midnight is within a minute", func(t *testing.T) {
+		store := NewIndexedStore(NewMemoryStore())
+		late := original
+		late.PurchaseDate, late.PurchaseTime = "2021-12-31", "23:59"
+		store.Put(late)
+		receipt := original
+		receipt.ID, receipt.PurchaseDate, receipt.PurchaseTime = "new", "2022-01-01", "00:00"
+		if _, found := FindDuplicate(store, receipt); !found {
+			t.Error("Expected 23:59 and 00:00 the next day to be near-duplicates")
+		}
+	})
+
+	t.Run("A deleted original is forgotten", func(t *testing.T) {
+		store := NewIndexedStore(NewMemoryStore())
+		store.Put(original)
+		store.Delete(original.ID)
+		receipt := original
+		receipt.ID = "new"
+		if _, found := FindDuplicate(store, receipt); found {
+			t.Error("Expected no duplicate after the original was deleted")
+		}
+	})
+}
+
+func TestSoftDeleteStore(t *testing.T) {
+	bounded := NewBoundedStore(NewIndexedStore(NewMemoryStore()), BoundedStoreOptions{MaxReceipts: 10})
+	defer bounded.Close()
+	store := NewSoftDeleteStore(bounded, SoftDeleteStoreOptions{PurgeAfter: time.Hour, SweepInterval: time.Hour})
+	defer store.Close()
+	clock := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return clock }
+
+	receipt := func(id, retailer string) Receipt {
+		return Receipt{ID: id, Retailer: retailer, PurchaseDate: "2024-02-01", PurchaseTime: "13:01", Total: "10.00",
+			Items: []Item{{ShortDescription: "Gatorade", Price: "10.00"}}}
+	}
+	store.Put(receipt("a", "Target"))
+	store.Put(receipt("b", "Walgreens"))
+
+	if _, found, _ := store.SoftDelete("a"); !found {
+		t.Fatal("Expected receipt a to be deleted")
+	}
+	if _, found, _ := store.SoftDelete("a"); found {
+		t.Error("Expected deleting a deleted receipt to report false")
+	}
+	if _, exists := store.Get("a"); exists {
+		t.Error("Expected a deleted receipt to be hidden from Get")
+	}
+	if list := store.List(); len(list) != 1 || list[0].ID != "b" {
+		t.Errorf("Expected only b listed, got %v", list)
+	}
+	if candidates, index := store.Candidates(ReceiptQuery{Retailer: "Target"}); index != IndexRetailer || len(candidates) != 0 {
+		t.Errorf("Expected the retailer index to find nothing live, got %d via %q", len(candidates), index)
+	}
+	if duplicate, found := FindDuplicate(store, receipt("c", "Target")); found {
+		t.Errorf("Expected a deleted receipt not to count as a duplicate, got %s", duplicate.Original.ID)
+	}
+	if deleted := store.Deleted(); len(deleted) != 1 || !deleted[0].DeletedAt.Equal(clock) || !store.PurgeAt(deleted[0]).Equal(clock.Add(time.Hour)) {
+		t.Errorf("Expected a deleted at %v, purged an hour later, got %+v", clock, deleted)
+	}
+	if stats, ok := UnwrapStore[*BoundedStore](store); !ok || stats.Stats().Receipts != 2 {
+		t.Error("Expected the bounded store underneath to still hold the deleted receipt")
+	}
+
+	restored, found, err := store.Restore("a")
+	if err != nil || !found || !restored.DeletedAt.IsZero() {
+		t.Fatalf("Expected a restored, got %+v %v %v", restored, found, err)
+	}
+	if _, exists := store.Get("a"); !exists {
+		t.Error("Expected a restored receipt to be found again")
+	}
+	if _, found, _ := store.Restore("b"); found {
+		t.Error("Expected restoring a receipt that is not deleted to report false")
+	}
+
+	store.SoftDelete("a")
+	clock = clock.Add(59 * time.Minute)
+	if purged, _ := store.Purge(); purged != 0 {
+		t.Errorf("Expected nothing purged inside the grace period, got %d", purged)
+	}
+	clock = clock.Add(time.Minute)
+	if purged, _ := store.Purge(); purged != 1 || store.TrashStats() != (TrashStats{Deleted: 0, Purged: 1}) {
+		t.Errorf("Expected a purged, got %d and %+v", purged, store.TrashStats())
+	}
+	if _, found, _ := store.Restore("a"); found || bounded.Stats().Receipts != 1 {
+		t.Error("Expected a purged receipt to be gone from every store")
+	}
+}
+
+func TestSoftDeleteKeepsBoundedOrder(t *testing.T) {
+	bounded := NewBoundedStore(NewMemoryStore(), BoundedStoreOptions{MaxReceipts: 2, TTL: time.Hour, Policy: EvictLRU, SweepInterval: time.Hour})
+	defer bounded.Close()
+	clock := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	bounded.now = func() time.Time { return clock }
+	store := NewSoftDeleteStore(bounded, SoftDeleteStoreOptions{})
+
+	store.Put(Receipt{ID: "a"})
+	clock = clock.Add(30 * time.Minute)
+	store.Put(Receipt{ID: "b"})
+
+	// deleting a must not make it the most recently used, so c evicts a, not b
+	store.SoftDelete("a")
+	store.Put(Receipt{ID: "c"})
+	if _, exists := store.Get("b"); !exists {
+		t.Error("Expected the live receipt b to survive; the deleted a should go first")
+	}
+	if _, found, _ := store.Restore("a"); found {
+		t.Error("Expected the deleted a to have been evicted")
+	}
+
+	// a delete and restore keep b's TTL from its last real write
+	store.SoftDelete("b")
+	clock = clock.Add(45 * time.Minute)
+	store.Restore("b")
+	clock = clock.Add(20 * time.Minute)
+	if _, exists := store.Get("b"); exists {
+		t.Error("Expected b to expire an hour after it was stored, not after it was restored")
+	}
+}
+
+func TestSoftDeleteStoreCountsTrash(t *testing.T) {
+	inner := NewMemoryStore()
+	inner.Put(Receipt{ID: "old", DeletedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+	store := NewSoftDeleteStore(inner, SoftDeleteStoreOptions{})
+	if stats := store.TrashStats(); stats.Deleted != 1 {
+		t.Errorf("Expected a receipt already deleted in the inner store to be counted, got %+v", stats)
+	}
+
+	store.Put(Receipt{ID: "a"})
+	store.Put(Receipt{ID: "b"})
+	store.SoftDelete("a")
+	store.SoftDelete("b")
+	store.SoftDelete("b")
+	store.Restore("a")
+	store.Delete("old")
+	if stats := store.TrashStats(); stats.Deleted != 1 {
+		t.Errorf("Expected only b in the trash, got %+v", stats)
+	}
+	if deleted := len(store.Deleted()); deleted != 1 {
+		t.Errorf("Expected the count to match Deleted, got %d", deleted)
+	}
+}
+
+func TestReviseKeepsMaxRevisions(t *testing.T) {
+	defer func(saved int) { MaxRevisions = saved }(MaxRevisions)
+	MaxRevisions = 3
+
+	receipt := Receipt{ID: "receipt-1"}
+	for i := 0; i < 5; i++ {
+		receipt = receipt.Revise(Receipt{Total: fmt.Sprint(i)}, time.Now())
+	}
+	if receipt.Revision != 6 || len(receipt.History) != 3 {
+		t.Fatalf("Expected revision 6 with 3 kept, got revision %d with %d", receipt.Revision, len(receipt.History))
+	}
+	for i, revision := range receipt.History {
+		if revision.Revision != uint(i+3) {
+			t.Errorf("Expected revisions 3-5 kept, got %d at %d", revision.Revision, i)
+		}
+	}
+}
+
+// failingWAL writes half of a record and then fails, or fails to fsync
+type failingWAL struct {
+	walFile
+	shortWrite bool
+	failSync   bool
+}
+
+func (f *failingWAL) Write(data []byte) (int, error) {
+	if f.shortWrite {
+		n, _ := f.walFile.Write(data[:len(data)/2])
+		return n, errors.New("disk full")
+	}
+	return f.walFile.Write(data)
+}
+
+func (f *failingWAL) Sync() error {
+	if f.failSync {
+		return errors.New("fsync failed")
+	}
+	return f.walFile.Sync()
+}
+
+func TestValidateStrictPurchasedAtReportedOnce(t *testing.T) {
+	for _, purchasedAt := range []string{"2024-02-07 13:45", "2024-02-07T13:45:00-05:00"} {
+		receipt := Receipt{
+			Retailer:    "Target",
+			PurchasedAt: purchasedAt,
+			Items:       []Item{{ShortDescription: "Gum", Price: "1.00"}},
+			Total:       "1.00",
+		}
+		var errs ValidationErrors
+		if !errors.As(receipt.ValidateReceiptWithProfile(ProfileStrict), &errs) {
+			t.Fatalf("%s: expected ValidationErrors", purchasedAt)
+		}
+		if len(errs) != 1 || errs[0].Pointer != "/purchasedAt" {
+			t.Errorf("%s: expected one error at /purchasedAt, got %+v", purchasedAt, errs)
+		}
+	}
+}
+
+func TestValidationErrorCodeFallback(t *testing.T) {
+	var errs ValidationErrors
+	errs.add("/total", "", "total is wrong", nil)
+	if errs[0].Code != CodeInvalidValue {
+		t.Errorf("Expected code %s, got %q", CodeInvalidValue, errs[0].Code)
+	}
+}
@@ -159,6 +159,143 @@ var ValidateReceiptTestCases = []struct {
         }`,
         IsValid: false,
     },
+    {
+        Name: "Valid PurchasedAt Timestamp",
+        JsonData: `{
+            "retailer": "Target",
+            "purchasedAt": "2024-02-07T13:45:00-05:00",
+            "total": "6.49",
+            "items": [
+                {
+                    "shortDescription": "Mountain Dew 12PK",
+                    "price": "6.49"
+                }
+            ]
+        }`,
+        IsValid: true,
+    },
+    {
+        Name: "PurchasedAt Combined With PurchaseDate",
+        JsonData: `{
+            "retailer": "Target",
+            "purchasedAt": "2024-02-07T13:45:00-05:00",
+            "purchaseDate": "2024-02-07",
+            "total": "6.49",
+            "items": [
+                {
+                    "shortDescription": "Mountain Dew 12PK",
+                    "price": "6.49"
+                }
+            ]
+        }`,
+        IsValid: false,
+    },
+    {
+        Name: "Invalid PurchasedAt Timestamp",
+        JsonData: `{
+            "retailer": "Target",
+            "purchasedAt": "2024-02-07 13:45",
+            "total": "6.49",
+            "items": [
+                {
+                    "shortDescription": "Mountain Dew 12PK",
+                    "price": "6.49"
+                }
+            ]
+        }`,
+        IsValid: false,
+    },
+}
+
+var ValidationProfileTestCases = []struct {
+    Name         string
+    JsonData     string
+    LenientValid bool
+    StrictValid  bool
+}{
+    {
+        Name: "Spec Conformant Receipt",
+        JsonData: `{
+            "retailer": "M&M Corner Market",
+            "purchaseDate": "2022-03-20",
+            "purchaseTime": "14:33",
+            "total": "2.25",
+            "items": [{"shortDescription": "Gatorade - 20oz", "price": "2.25"}]
+        }`,
+        LenientValid: true,
+        StrictValid:  true,
+    },
+    {
+        Name: "Price With One Decimal",
+        JsonData: `{
+            "retailer": "Target",
+            "purchaseDate": "2022-03-20",
+            "purchaseTime": "14:33",
+            "total": "1.5",
+            "items": [{"shortDescription": "Gum", "price": "1.5"}]
+        }`,
+        LenientValid: true,
+        StrictValid:  false,
+    },
+    {
+        Name: "US Date And 12-Hour Time",
+        JsonData: `{
+            "retailer": "Target",
+            "purchaseDate": "03/20/2022",
+            "purchaseTime": "2:33 PM",
+            "total": "1.50",
+            "items": [{"shortDescription": "Gum", "price": "1.50"}]
+        }`,
+        LenientValid: true,
+        StrictValid:  false,
+    },
+    {
+        Name: "Retailer With Punctuation",
+        JsonData: `{
+            "retailer": "Walmart!",
+            "purchaseDate": "2022-03-20",
+            "purchaseTime": "14:33",
+            "total": "1.50",
+            "items": [{"shortDescription": "Gum", "price": "1.50"}]
+        }`,
+        LenientValid: true,
+        StrictValid:  false,
+    },
+    {
+        Name: "Description With Ampersand",
+        JsonData: `{
+            "retailer": "Target",
+            "purchaseDate": "2022-03-20",
+            "purchaseTime": "14:33",
+            "total": "1.50",
+            "items": [{"shortDescription": "Salt & Pepper", "price": "1.50"}]
+        }`,
+        LenientValid: true,
+        StrictValid:  false,
+    },
+    {
+        Name: "PurchasedAt Timestamp",
+        JsonData: `{
+            "retailer": "Target",
+            "purchasedAt": "2022-03-20T14:33:00-05:00",
+            "total": "1.50",
+            "items": [{"shortDescription": "Gum", "price": "1.50"}]
+        }`,
+        LenientValid: true,
+        StrictValid:  false,
+    },
+    {
+        Name: "NaN Price And Total",
+        JsonData: `{
+            "retailer": "Target",
+            "purchaseDate": "2022-03-20",
+            "purchaseTime": "14:33",
+            "total": "NaN",
+            "items": [{"shortDescription": "Gum", "price": "NaN"}]
+        }`,
+        LenientValid: false,
+        StrictValid:  false,
+    },
 }
 
 var CalculatePointsTestCases = []struct {
@@ -0,0 +1,52 @@
+// model/revision.go
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// MaxRevisions is how many replaced versions a receipt keeps; older ones are
+// dropped on the next edit (0 keeps all). main.go overrides it from flags.
+var MaxRevisions = 20
+
+// Revision is a receipt as it was before an edit replaced it.
+type Revision struct {
+	Revision   uint      `json:"revision" xml:"number,attr"`
+	Points     uint      `json:"points" xml:"points"`
+	ReplacedAt time.Time `json:"replacedAt" xml:"replacedAt"`
+	Receipt    Receipt   `json:"receipt" xml:"receipt"`
+}
+
+// CurrentRevision numbers a receipt's versions from 1; receipts stored before
+// edits were tracked have a zero Revision and count as the first.
+func (r Receipt) CurrentRevision() uint {
+	return max(r.Revision, 1)
+}
+
+// ETag is the strong entity tag for the receipt's current revision.
+func (r Receipt) ETag() string {
+	return strconv.Quote(strconv.FormatUint(uint64(r.CurrentRevision()), 10))
+}
+
+// Revise makes updated the next revision of r: it keeps r's ID and history,
+// with r itself appended as the latest prior version, up to MaxRevisions.
+func (r Receipt) Revise(updated Receipt, at time.Time) Receipt {
+	prior := r
+	prior.Revision = r.CurrentRevision()
+	prior.History = nil
+
+	updated.ID = r.ID
+	updated.Revision = prior.Revision + 1
+	kept := r.History
+	if MaxRevisions > 0 && len(kept) >= MaxRevisions {
+		kept = kept[len(kept)-MaxRevisions+1:]
+	}
+	updated.History = append(append([]Revision{}, kept...), Revision{
+		Revision:   prior.Revision,
+		Points:     prior.Points,
+		ReplacedAt: at.UTC(),
+		Receipt:    prior,
+	})
+	return updated
+}
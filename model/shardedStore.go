@@ -0,0 +1,122 @@
+// model/shardedStore.go
+package model
+
+import (
+	"runtime"
+	"sync"
+)
+
+// ShardedStore is an in-memory ReceiptStore split into shards by ID hash, each
+// with its own RWMutex. Reads only share-lock one shard, and a List or Iterate
+// locks one shard at a time, so neither holds up writes to the rest.
+type ShardedStore struct {
+	shards []storeShard
+	mask   uint32
+}
+
+type storeShard struct {
+	mu       sync.RWMutex
+	receipts map[string]Receipt
+	// keep each shard's lock on its own cache line
+	_ [64]byte
+}
+
+// NewShardedStore rounds shards up to a power of two; 0 picks four per CPU.
+func NewShardedStore(shards int) *ShardedStore {
+	if shards <= 0 {
+		shards = 4 * runtime.GOMAXPROCS(0)
+	}
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+	s := &ShardedStore{shards: make([]storeShard, n), mask: uint32(n - 1)}
+	for i := range s.shards {
+		s.shards[i].receipts = make(map[string]Receipt)
+	}
+	return s
+}
+
+func (s *ShardedStore) shard(id string) *storeShard {
+	return &s.shards[fnv32a(id)&s.mask]
+}
+
+// fnv32a is the FNV-1a hash of s, used to spread IDs over shards and locks
+func fnv32a(s string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		hash ^= uint32(s[i])
+		hash *= 16777619
+	}
+	return hash
+}
+
+func (s *ShardedStore) Put(receipt Receipt) error {
+	shard := s.shard(receipt.ID)
+	shard.mu.Lock()
+	shard.receipts[receipt.ID] = receipt
+	shard.mu.Unlock()
+	return nil
+}
+
+func (s *ShardedStore) Get(id string) (Receipt, bool) {
+	shard := s.shard(id)
+	shard.mu.RLock()
+	receipt, exists := shard.receipts[id]
+	shard.mu.RUnlock()
+	return receipt, exists
+}
+
+func (s *ShardedStore) List() []Receipt {
+	receipts := make([]Receipt, 0, s.Len())
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		for _, receipt := range shard.receipts {
+			receipts = append(receipts, receipt)
+		}
+		shard.mu.RUnlock()
+	}
+	return receipts
+}
+
+func (s *ShardedStore) Delete(id string) (bool, error) {
+	shard := s.shard(id)
+	shard.mu.Lock()
+	_, exists := shard.receipts[id]
+	delete(shard.receipts, id)
+	shard.mu.Unlock()
+	return exists, nil
+}
+
+// Iterate copies one shard at a time, so no lock is held while fn runs.
+func (s *ShardedStore) Iterate(fn func(Receipt) bool) {
+	var batch []Receipt
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		batch = batch[:0]
+		for _, receipt := range shard.receipts {
+			batch = append(batch, receipt)
+		}
+		shard.mu.RUnlock()
+
+		for _, receipt := range batch {
+			if !fn(receipt) {
+				return
+			}
+		}
+	}
+}
+
+// Len is the number of receipts, counted shard by shard.
+func (s *ShardedStore) Len() int {
+	n := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		n += len(shard.receipts)
+		shard.mu.RUnlock()
+	}
+	return n
+}
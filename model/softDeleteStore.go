@@ -0,0 +1,269 @@
+// model/softDeleteStore.go
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// SoftDeleteStoreOptions control how long deleted receipts can be restored.
+type SoftDeleteStoreOptions struct {
+	// PurgeAfter is how long after deletion a receipt is removed for good
+	// (0 keeps it until it is restored)
+	PurgeAfter time.Duration
+	// SweepInterval is how often purges run in the background
+	// (default PurgeAfter/10, at least a second)
+	SweepInterval time.Duration
+}
+
+// TrashStats count the receipts a SoftDeleteStore is holding back.
+type TrashStats struct {
+	Deleted int
+	Purged  uint64
+}
+
+// SoftDeleteStore wraps another store so that a deleted receipt is kept,
+// marked with DeletedAt, until it is restored or purged. Deleted receipts are
+// not found, listed, iterated, queried or matched as duplicates, so their
+// points no longer count. Delete still removes a receipt at once.
+type SoftDeleteStore struct {
+	inner   ReceiptStore
+	options SoftDeleteStoreOptions
+	now     func() time.Time
+
+	// mu makes each SoftDelete, Restore and Purge read-modify-write one step
+	mu sync.Mutex
+	// deleted counts the receipts in the trash, so TrashStats need not scan
+	// for them; Purge recounts, which also drops any an inner BoundedStore
+	// evicted while deleted
+	deleted int
+	purged  uint64
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func NewSoftDeleteStore(inner ReceiptStore, options SoftDeleteStoreOptions) *SoftDeleteStore {
+	s := &SoftDeleteStore{inner: inner, options: options, now: time.Now}
+	// a FileStore may reopen with receipts still in the trash
+	s.deleted = len(s.Deleted())
+	if options.PurgeAfter > 0 {
+		interval := options.SweepInterval
+		if interval <= 0 {
+			interval = max(options.PurgeAfter/10, time.Second)
+		}
+		s.stop, s.stopped = make(chan struct{}), make(chan struct{})
+		go s.purgeLoop(interval)
+	}
+	return s
+}
+
+func (s *SoftDeleteStore) Put(receipt Receipt) error {
+	return s.inner.Put(receipt)
+}
+
+func (s *SoftDeleteStore) Get(id string) (Receipt, bool) {
+	receipt, exists := s.inner.Get(id)
+	if !exists || !receipt.DeletedAt.IsZero() {
+		return Receipt{}, false
+	}
+	return receipt, true
+}
+
+func (s *SoftDeleteStore) List() []Receipt {
+	return liveReceipts(s.inner.List())
+}
+
+func (s *SoftDeleteStore) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	receipt, exists := s.inner.Get(id)
+	deleted, err := s.inner.Delete(id)
+	if deleted && exists && !receipt.DeletedAt.IsZero() {
+		s.deleted--
+	}
+	return deleted, err
+}
+
+func (s *SoftDeleteStore) Iterate(fn func(Receipt) bool) {
+	s.inner.Iterate(func(receipt Receipt) bool {
+		return !receipt.DeletedAt.IsZero() || fn(receipt)
+	})
+}
+
+// Unwrap returns the store that SoftDeleteStore wraps.
+func (s *SoftDeleteStore) Unwrap() ReceiptStore {
+	return s.inner
+}
+
+// Candidates forwards to the inner store's indexes, dropping deleted receipts.
+func (s *SoftDeleteStore) Candidates(q ReceiptQuery) ([]Receipt, string) {
+	planner, ok := s.inner.(QueryPlanner)
+	if !ok {
+		return nil, ""
+	}
+	candidates, index := planner.Candidates(q)
+	return liveReceipts(candidates), index
+}
+
+// FindDuplicate uses the inner store's index, scanning the live receipts
+// instead when the closest match there is deleted.
+func (s *SoftDeleteStore) FindDuplicate(receipt Receipt) (Duplicate, bool) {
+	if finder, ok := s.inner.(DuplicateFinder); ok {
+		duplicate, found := finder.FindDuplicate(receipt)
+		if !found || duplicate.Original.DeletedAt.IsZero() {
+			return duplicate, found
+		}
+	}
+	return scanForDuplicate(s, receipt)
+}
+
+// SoftDelete hides a receipt until it is restored or purged. It reports false
+// when there is no such receipt or it is already deleted.
+func (s *SoftDeleteStore) SoftDelete(id string) (Receipt, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	receipt, exists := s.Get(id)
+	if !exists {
+		return Receipt{}, false, nil
+	}
+	receipt.DeletedAt = s.now().UTC()
+	if err := s.putMark(receipt); err != nil {
+		return Receipt{}, false, err
+	}
+	s.deleted++
+	return receipt, true, nil
+}
+
+// Restore brings back a deleted receipt that has not been purged yet. It
+// reports false when there is no deleted receipt with that ID.
+func (s *SoftDeleteStore) Restore(id string) (Receipt, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	receipt, exists := s.inner.Get(id)
+	if !exists || receipt.DeletedAt.IsZero() {
+		return Receipt{}, false, nil
+	}
+	receipt.DeletedAt = time.Time{}
+	if err := s.putMark(receipt); err != nil {
+		return Receipt{}, false, err
+	}
+	s.deleted--
+	return receipt, true, nil
+}
+
+// inPlaceWriter is a store where setting or clearing a deletion mark should not
+// count as a fresh write, such as BoundedStore
+type inPlaceWriter interface {
+	PutInPlace(receipt Receipt) error
+}
+
+func (s *SoftDeleteStore) putMark(receipt Receipt) error {
+	if writer, ok := s.inner.(inPlaceWriter); ok {
+		return writer.PutInPlace(receipt)
+	}
+	return s.inner.Put(receipt)
+}
+
+// Deleted returns the receipts waiting to be restored or purged.
+func (s *SoftDeleteStore) Deleted() []Receipt {
+	var deleted []Receipt
+	s.inner.Iterate(func(receipt Receipt) bool {
+		if !receipt.DeletedAt.IsZero() {
+			deleted = append(deleted, receipt)
+		}
+		return true
+	})
+	return deleted
+}
+
+// PurgeAt is when a deleted receipt will be removed for good; zero when
+// deleted receipts are kept until restored.
+func (s *SoftDeleteStore) PurgeAt(receipt Receipt) time.Time {
+	if s.options.PurgeAfter <= 0 || receipt.DeletedAt.IsZero() {
+		return time.Time{}
+	}
+	return receipt.DeletedAt.Add(s.options.PurgeAfter)
+}
+
+// Purge removes the deleted receipts whose grace period has passed and
+// returns how many it removed.
+func (s *SoftDeleteStore) Purge() (int, error) {
+	if s.options.PurgeAfter <= 0 {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	trash := s.Deleted()
+	s.deleted = len(trash)
+	purged := 0
+	for _, receipt := range trash {
+		if now.Before(s.PurgeAt(receipt)) {
+			continue
+		}
+		if _, err := s.inner.Delete(receipt.ID); err != nil {
+			return purged, err
+		}
+		purged++
+		s.purged++
+		s.deleted--
+	}
+	return purged, nil
+}
+
+func (s *SoftDeleteStore) TrashStats() TrashStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return TrashStats{Deleted: s.deleted, Purged: s.purged}
+}
+
+// Close stops the background purge.
+func (s *SoftDeleteStore) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.stopped
+		s.stop = nil
+	}
+	return nil
+}
+
+func (s *SoftDeleteStore) purgeLoop(interval time.Duration) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.Purge()
+		}
+	}
+}
+
+func liveReceipts(receipts []Receipt) []Receipt {
+	live := receipts[:0]
+	for _, receipt := range receipts {
+		if receipt.DeletedAt.IsZero() {
+			live = append(live, receipt)
+		}
+	}
+	return live
+}
+
+// UnwrapStore looks through store and the stores it wraps (via Unwrap) for
+// one of type T, e.g. the BoundedStore under a SoftDeleteStore.
+func UnwrapStore[T any](store ReceiptStore) (T, bool) {
+	for store != nil {
+		if found, ok := store.(T); ok {
+			return found, true
+		}
+		wrapper, ok := store.(interface{ Unwrap() ReceiptStore })
+		if !ok {
+			break
+		}
+		store = wrapper.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
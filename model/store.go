@@ -0,0 +1,80 @@
+// model/store.go
+package model
+
+import "sync"
+
+// ReceiptStore is where processed receipts live. Handlers are given one
+// instead of reaching for package state, so stores can be isolated, faked or
+// made persistent.
+type ReceiptStore interface {
+	// Put stores the receipt under its ID, replacing any receipt with that ID.
+	Put(receipt Receipt) error
+	Get(id string) (Receipt, bool)
+	// List returns every receipt, in no particular order.
+	List() []Receipt
+	// Delete reports whether a receipt with that ID existed.
+	Delete(id string) (bool, error)
+	// Iterate calls fn for each receipt until fn returns false. fn may use the
+	// store; receipts added or removed meanwhile may or may not be visited.
+	Iterate(fn func(Receipt) bool)
+}
+
+// MemoryStore is a ReceiptStore backed by a map; the zero value is not usable,
+// use NewMemoryStore.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	receipts map[string]Receipt
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{receipts: make(map[string]Receipt)}
+}
+
+func (s *MemoryStore) Put(receipt Receipt) error {
+	s.mu.Lock()
+	s.receipts[receipt.ID] = receipt
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (Receipt, bool) {
+	s.mu.RLock()
+	receipt, exists := s.receipts[id]
+	s.mu.RUnlock()
+	return receipt, exists
+}
+
+func (s *MemoryStore) List() []Receipt {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	receipts := make([]Receipt, 0, len(s.receipts))
+	for _, receipt := range s.receipts {
+		receipts = append(receipts, receipt)
+	}
+	return receipts
+}
+
+func (s *MemoryStore) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	_, exists := s.receipts[id]
+	delete(s.receipts, id)
+	s.mu.Unlock()
+	return exists, nil
+}
+
+// Iterate snapshots only the IDs up front, so the lock is never held while fn runs.
+func (s *MemoryStore) Iterate(fn func(Receipt) bool) {
+	s.mu.RLock()
+	ids := make([]string, 0, len(s.receipts))
+	for id := range s.receipts {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+
+	for _, id := range ids {
+		// skip receipts removed since the snapshot
+		if receipt, exists := s.Get(id); exists && !fn(receipt) {
+			return
+		}
+	}
+}
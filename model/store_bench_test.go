@@ -0,0 +1,138 @@
+// model/store_bench_test.go
+package model
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Compare scaling across GOMAXPROCS with:
+//
+//	go test ./model -run '^$' -bench Store -cpu 1,2,4,8
+var benchStores = []struct {
+	name string
+	new  func() ReceiptStore
+}{
+	{"Memory", func() ReceiptStore { return NewMemoryStore() }},
+	{"Sharded", func() ReceiptStore { return NewShardedStore(0) }},
+	{"Indexed", func() ReceiptStore { return NewIndexedStore(NewShardedStore(0)) }},
+}
+
+const benchReceipts = 10000
+
+// benchReceipt spreads receipts over a year of dates, 100 point totals, 3
+// retailers and distinct totals, so the indexes have realistic key counts
+func benchReceipt(i int) Receipt {
+	return Receipt{
+		ID:           strconv.Itoa(i),
+		Retailer:     []string{"Target", "Walgreens", "M&M Corner Market"}[i%3],
+		PurchaseDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i%365).Format("2006-01-02"),
+		Total:        strconv.Itoa(i) + ".35",
+		Points:       uint(i % 100),
+	}
+}
+
+func filledStore(newStore func() ReceiptStore) ReceiptStore {
+	store := newStore()
+	for i := 0; i < benchReceipts; i++ {
+		store.Put(benchReceipt(i))
+	}
+	return store
+}
+
+// BenchmarkStoreMixed is Gets and Puts from every goroutine; readPercent of
+// the operations are reads.
+func BenchmarkStoreMixed(b *testing.B) {
+	for _, readPercent := range []int{100, 90, 50} {
+		for _, s := range benchStores {
+			b.Run(s.name+"/reads="+strconv.Itoa(readPercent)+"%", func(b *testing.B) {
+				store := filledStore(s.new)
+				var seed atomic.Uint64
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					// a per-goroutine xorshift, so the generator is not a shared point of contention
+					x := seed.Add(0x9e3779b97f4a7c15)
+					for pb.Next() {
+						x ^= x << 13
+						x ^= x >> 7
+						x ^= x << 17
+						id := strconv.Itoa(int(x % benchReceipts))
+						if int(x>>32%100) < readPercent {
+							store.Get(id)
+						} else {
+							store.Put(benchReceipt(int(x % benchReceipts)))
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkStorePutDuringList measures Puts while another goroutine lists the
+// whole store every 10ms, the case where a single lock stalls every POST.
+func BenchmarkStorePutDuringList(b *testing.B) {
+	for _, s := range benchStores {
+		b.Run(s.name, func(b *testing.B) {
+			store := filledStore(s.new)
+			stop, stopped := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(stopped)
+				ticker := time.NewTicker(10 * time.Millisecond)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-stop:
+						return
+					case <-ticker.C:
+						store.List()
+					}
+				}
+			}()
+
+			var next atomic.Uint64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					store.Put(benchReceipt(int(next.Add(1) % benchReceipts)))
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			<-stopped
+		})
+	}
+}
+
+// BenchmarkIndexedQueryDuringPut measures Puts while another goroutine runs an
+// indexed query for about a month of receipts every millisecond.
+func BenchmarkIndexedQueryDuringPut(b *testing.B) {
+	store := filledStore(benchStores[2].new).(*IndexedStore)
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				store.Candidates(ReceiptQuery{From: "2024-03-01", To: "2024-03-31"})
+			}
+		}
+	}()
+
+	var next atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			store.Put(benchReceipt(int(next.Add(1) % benchReceipts)))
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-stopped
+}
@@ -0,0 +1,142 @@
+// model/validation.go
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ValidationProfile selects how closely a receipt must follow api.yml.
+type ValidationProfile string
+
+const (
+	// ProfileLenient accepts the extra date/time/price formats we normalize.
+	ProfileLenient ValidationProfile = "lenient"
+	// ProfileStrict enforces the OpenAPI patterns and formats exactly.
+	ProfileStrict ValidationProfile = "strict"
+)
+
+// DefaultValidationProfile is used by ValidateReceipt; main.go overrides it per deployment.
+var DefaultValidationProfile = ProfileLenient
+
+// Patterns from api.yml (components.schemas.Receipt / Item)
+var (
+	specRetailerPattern    = regexp.MustCompile(`^[\w\s\-&]+$`)
+	specDescriptionPattern = regexp.MustCompile(`^[\w\s\-]+$`)
+	specAmountPattern      = regexp.MustCompile(`^\d+\.\d{2}$`)
+	// format: date (RFC 3339 full-date) and 24-hour HH:MM time
+	specDatePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	specTimePattern = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d$`)
+)
+
+func ParseValidationProfile(profile string) (ValidationProfile, error) {
+	switch ValidationProfile(strings.ToLower(strings.TrimSpace(profile))) {
+	case ProfileLenient:
+		return ProfileLenient, nil
+	case ProfileStrict:
+		return ProfileStrict, nil
+	}
+	return "", fmt.Errorf("unknown validation profile %q (expected lenient or strict)", profile)
+}
+
+// validateSpecConformance adds the strict-only checks to errs.
+func (receipt *Receipt) validateSpecConformance(errs *ValidationErrors) {
+	if !specRetailerPattern.MatchString(receipt.Retailer) {
+		errs.add("/retailer", CodePatternMismatch, "retailer must match "+specRetailerPattern.String(), nil)
+	}
+
+	// purchaseDate + purchaseTime are required by the spec, so purchasedAt is not
+	// accepted; the one error is reported on purchasedAt, not on the fields it stands in for
+	if receipt.PurchasedAt != "" {
+		errs.add("/purchasedAt", CodeNotAllowed, "purchasedAt is not part of the receipt schema", nil)
+	} else {
+		if !specDatePattern.MatchString(receipt.PurchaseDate) {
+			errs.add("/purchaseDate", requiredOr(receipt.PurchaseDate, CodePatternMismatch), "purchaseDate must be a YYYY-MM-DD date", nil)
+		}
+		if !specTimePattern.MatchString(receipt.PurchaseTime) {
+			errs.add("/purchaseTime", requiredOr(receipt.PurchaseTime, CodePatternMismatch), "purchaseTime must be a 24-hour HH:MM time", nil)
+		}
+	}
+
+	for i, item := range receipt.Items {
+		if !specDescriptionPattern.MatchString(item.ShortDescription) {
+			errs.add(fmt.Sprintf("/items/%d/shortDescription", i), CodePatternMismatch,
+				"shortDescription must match "+specDescriptionPattern.String(), nil)
+		}
+		if !specAmountPattern.MatchString(item.Price) {
+			errs.add(fmt.Sprintf("/items/%d/price", i), CodePatternMismatch, "price must match "+specAmountPattern.String(), nil)
+		}
+	}
+
+	if !specAmountPattern.MatchString(receipt.Total) {
+		errs.add("/total", CodePatternMismatch, "total must match "+specAmountPattern.String(), nil)
+	}
+}
+
+// Machine-readable validation error codes
+const (
+	CodeRequired        = "required"
+	CodeInvalidFormat   = "invalid_format"
+	CodePatternMismatch = "pattern_mismatch"
+	CodeOutOfRange      = "out_of_range"
+	CodeConflict        = "conflict"
+	CodeNotAllowed      = "not_allowed"
+	CodeTotalMismatch   = "total_mismatch"
+	CodeDateInFuture    = "date_in_future"
+	CodeDateTooOld      = "date_too_old"
+	// CodeInvalidValue is the fallback for a problem without a more specific code
+	CodeInvalidValue = "invalid_value"
+)
+
+// ValidationError is one problem with a receipt. Pointer is a JSON pointer
+// (RFC 6901) to the offending field, e.g. /items/2/price.
+type ValidationError struct {
+	Pointer string `json:"pointer"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	err     error
+}
+
+// ValidationErrors is every problem found with a receipt, in field order.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Pointer + ": " + e.Message
+	}
+	return standardErrorPrefix + strings.Join(messages, "\n   ")
+}
+
+// Unwrap exposes underlying causes (e.g. *config.DatePolicyError) to errors.As.
+func (errs ValidationErrors) Unwrap() []error {
+	var causes []error
+	for _, e := range errs {
+		if e.err != nil {
+			causes = append(causes, e.err)
+		}
+	}
+	return causes
+}
+
+// add records a problem unless the field already has one.
+func (errs *ValidationErrors) add(pointer, code, message string, cause error) {
+	for _, e := range *errs {
+		if e.Pointer == pointer {
+			return
+		}
+	}
+	if code == "" {
+		code = CodeInvalidValue
+	}
+	*errs = append(*errs, ValidationError{Pointer: pointer, Code: code, Message: message, err: cause})
+}
+
+// requiredOr reports a missing value as required rather than malformed.
+func requiredOr(value, code string) string {
+	if value == "" {
+		return CodeRequired
+	}
+	return code
+}
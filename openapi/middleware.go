@@ -0,0 +1,107 @@
+// openapi/middleware.go
+package openapi
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// maxValidatedBody caps how much of a request or response body is buffered for
+// validation; larger bodies pass through unchecked.
+const maxValidatedBody = 1 << 20
+
+// Middleware validates every request and response of a documented operation
+// against the spec and logs each mismatch through logf. It never changes what
+// the handler sends, so it is meant for development, to catch handlers drifting
+// from the contract. Operations the spec does not describe are logged once.
+func (s *Spec) Middleware(next http.Handler, logf func(format string, args ...any)) http.Handler {
+	var undocumented sync.Map
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation, pathParams := s.Find(r.Method, r.URL.Path)
+		if operation == nil {
+			if _, seen := undocumented.LoadOrStore(r.Method+" "+r.URL.Path, true); !seen {
+				logf("openapi: %s %s is not in the spec", r.Method, r.URL.Path)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body := []byte{}
+		if operation.RequestBody != nil && r.Body != nil {
+			var complete bool
+			if body, complete = peekBody(r); !complete {
+				logf("openapi: %s %s: request body over %d bytes was not validated", r.Method, r.URL.Path, maxValidatedBody)
+				body = nil
+			}
+		}
+		for _, m := range operation.ValidateRequest(r, pathParams, body) {
+			logf("openapi: %s %s: request %s", r.Method, r.URL.Path, m)
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.overflow {
+			logf("openapi: %s %s: response body over %d bytes was not validated", r.Method, r.URL.Path, maxValidatedBody)
+			return
+		}
+		responseBody := recorder.body.Bytes()
+		if r.Method == http.MethodHead {
+			responseBody = nil
+		}
+		for _, m := range operation.ValidateResponse(recorder.status, recorder.Header().Get("Content-Type"), responseBody) {
+			logf("openapi: %s %s: response %d %s", r.Method, r.URL.Path, recorder.status, m)
+		}
+	})
+}
+
+// peekBody reads up to maxValidatedBody of the request body and puts it back so
+// the handler still sees the whole stream
+func peekBody(r *http.Request) ([]byte, bool) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedBody+1))
+	complete := err == nil && len(data) <= maxValidatedBody
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	return data, complete
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// responseRecorder passes the response through while keeping a copy of the
+// status and (bounded) body
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	overflow    bool
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status, rr.wroteHeader = status, true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	rr.wroteHeader = true
+	if !rr.overflow {
+		if rr.body.Len()+len(data) > maxValidatedBody {
+			rr.overflow = true
+			rr.body.Reset()
+		} else {
+			rr.body.Write(data)
+		}
+	}
+	return rr.ResponseWriter.Write(data)
+}
+
+// Unwrap lets http.ResponseController reach Flush and full-duplex support
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
@@ -0,0 +1,233 @@
+// openapi/openapi_test.go
+package openapi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func loadSpec(t *testing.T) *Spec {
+	t.Helper()
+	data, err := os.ReadFile("../api.yml")
+	if err != nil {
+		t.Fatalf("Failed to read api.yml: %v", err)
+	}
+	spec, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse api.yml: %v", err)
+	}
+	return spec
+}
+
+func TestParseYAML(t *testing.T) {
+	document := `
+# comment
+name: "quoted # not a comment" # trailing comment
+plain: "value with: colon"
+escaped: "caf\u00e9\ttab \N\_\e"
+count: 3
+ratio: 1.5
+enabled: true
+empty:
+list:
+- a
+- 'it''s'
+nested:
+    - key: one
+      other: 2
+    - [x, "y", 3]
+block: |
+    line one
+    line two
+"200":
+    description: ok
+201: {description: created, headers: [a, b]}
+base: &base
+    type: string
+derived:
+    <<: *base
+    format: date
+alias: *base
+`
+	got, err := parseYAML([]byte(document))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]any{
+		"name":    "quoted # not a comment",
+		"plain":   "value with: colon",
+		"escaped": "caf\u00e9\ttab \u0085\u00a0\x1b",
+		"count":   int64(3),
+		"ratio":   1.5,
+		"enabled": true,
+		"empty":   nil,
+		"list":    []any{"a", "it's"},
+		"nested": []any{
+			map[string]any{"key": "one", "other": int64(2)},
+			[]any{"x", "y", int64(3)},
+		},
+		"block":   "line one\nline two\n",
+		"200":     map[string]any{"description": "ok"},
+		"201":     map[string]any{"description": "created", "headers": []any{"a", "b"}},
+		"base":    map[string]any{"type": "string"},
+		"derived": map[string]any{"type": "string", "format": "date"},
+		"alias":   map[string]any{"type": "string"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, got)
+	}
+
+	for _, invalid := range []string{"a: 1\na: 2", "a: 1\n  b: 2", "\tkey: value", `a: "unterminated`} {
+		if _, err := parseYAML([]byte(invalid)); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	spec := loadSpec(t)
+	if spec.Title != "Receipt Processor" || len(spec.Operations) != 2 {
+		t.Fatalf("Unexpected spec %+v", spec)
+	}
+
+	operation, params := spec.Find(http.MethodGet, "/receipts/abc/points")
+	if operation == nil || operation.Path != "/receipts/{id}/points" || params["id"] != "abc" {
+		t.Fatalf("Expected the points operation, got %+v %v", operation, params)
+	}
+	if operation, _ := spec.Find(http.MethodHead, "/receipts/abc/points"); operation == nil {
+		t.Errorf("Expected HEAD to fall back to GET")
+	}
+	if operation, _ := spec.Find(http.MethodGet, "/receipts/process"); operation != nil {
+		t.Errorf("Expected no GET /receipts/process, got %+v", operation)
+	}
+
+	process, _ := spec.Find(http.MethodPost, "/receipts/process")
+	receipt := process.RequestBody.Content["application/json"]
+	if receipt == nil || receipt.Name != "Receipt" || receipt.Properties["items"].Items.Name != "Item" {
+		t.Errorf("Expected $refs to resolve to Receipt and Item, got %+v", receipt)
+	}
+	if process.Response(http.StatusBadRequest).Description != "The receipt is invalid." {
+		t.Errorf("Expected the BadRequest response to resolve, got %+v", process.Response(http.StatusBadRequest))
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	spec := loadSpec(t)
+	operation, params := spec.Find(http.MethodPost, "/receipts/process")
+
+	testCases := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{
+			"valid",
+			`{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"}`,
+			nil,
+		},
+		{
+			"lenient formats the spec does not allow",
+			`{"retailer": "Target", "purchaseDate": "01/01/2022", "purchaseTime": "1:01 PM", "items": [{"shortDescription": "Dew", "price": "6.5"}], "total": "6.50"}`,
+			[]string{"/items/0/price", "/purchaseDate", "/purchaseTime"},
+		},
+		{
+			"missing fields and wrong types",
+			`{"retailer": "Target", "items": [], "total": 6.49}`,
+			[]string{"/purchaseDate", "/purchaseTime", "/items", "/total"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/receipts/process", nil)
+			req.Header.Set("Content-Type", "application/json")
+			var pointers []string
+			for _, m := range operation.ValidateRequest(req, params, []byte(tc.body)) {
+				pointers = append(pointers, m.Pointer)
+			}
+			if !reflect.DeepEqual(pointers, tc.expected) {
+				t.Errorf("Expected mismatches at %v, got %v", tc.expected, pointers)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/receipts/process", nil)
+	req.Header.Set("Content-Type", "application/xml")
+	if mismatches := operation.ValidateRequest(req, params, []byte("<receipt/>")); len(mismatches) != 1 {
+		t.Errorf("Expected an undocumented content type, got %v", mismatches)
+	}
+	if mismatches := operation.ValidateRequest(req, params, []byte{}); len(mismatches) != 1 {
+		t.Errorf("Expected a missing required body, got %v", mismatches)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	spec := loadSpec(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/receipts/process":
+			// the handler must still see the whole body
+			if body, _ := io.ReadAll(r.Body); string(body) != `{"retailer": "Target"}` {
+				http.Error(w, "body was consumed", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"points": 10}`))
+		case "/receipts/drift/points":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"points": "ten"}`))
+		default:
+			w.WriteHeader(http.StatusTeapot)
+		}
+	})
+
+	var logged []string
+	logf := func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }
+	server := spec.Middleware(handler, logf)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve(http.MethodPost, "/receipts/process", `{"retailer": "Target"}`)
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"points": 10}` {
+		t.Errorf("Expected the handler's response to pass through unchanged, got %d %s", rr.Code, rr.Body.String())
+	}
+	serve(http.MethodGet, "/receipts/drift/points", "")
+	serve(http.MethodGet, "/receipts/ok/teapot", "")
+	serve(http.MethodGet, "/receipts/ok/teapot", "")
+
+	expected := []string{
+		"POST /receipts/process: request /purchaseDate: is required",
+		"POST /receipts/process: response 200 /id: is required",
+		"GET /receipts/drift/points: response 200 /points: expected integer, got string",
+		"GET /receipts/ok/teapot is not in the spec",
+	}
+	for _, e := range expected {
+		found := false
+		for _, line := range logged {
+			found = found || strings.Contains(line, e)
+		}
+		if !found {
+			t.Errorf("Expected a log line containing %q, got %v", e, logged)
+		}
+	}
+	undocumented := 0
+	for _, line := range logged {
+		if strings.Contains(line, "teapot") {
+			undocumented++
+		}
+	}
+	if undocumented != 1 {
+		t.Errorf("Expected an undocumented operation to be logged once, got %d", undocumented)
+	}
+}
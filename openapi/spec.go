@@ -0,0 +1,425 @@
+// openapi/spec.go
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Spec is the parsed subset of an OpenAPI 3 document the server serves and
+// validates against: operations, their parameters, bodies and responses, and
+// the component schemas they reference.
+type Spec struct {
+	Raw         []byte
+	Title       string
+	Description string
+	Version     string
+	Operations  []*Operation
+	Schemas     []*Schema // named component schemas, in name order
+}
+
+type Operation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Parameters  []*Parameter
+	RequestBody *RequestBody
+	Responses   []*Response // ordered by status code
+}
+
+type Parameter struct {
+	Name        string
+	In          string
+	Required    bool
+	Description string
+	Schema      *Schema
+}
+
+type RequestBody struct {
+	Required bool
+	Content  map[string]*Schema // media type to schema (nil when none is given)
+}
+
+type Response struct {
+	Status      string // "200", "4XX" or "default"
+	Description string
+	Content     map[string]*Schema
+}
+
+// Schema is the JSON Schema subset used by api.yml
+type Schema struct {
+	Name        string // component name, when it came from a $ref
+	Type        string
+	Format      string
+	Description string
+	Pattern     *regexp.Regexp
+	Enum        []any
+	Required    []string
+	Properties  map[string]*Schema
+	Items       *Schema
+	MinItems    *int
+	MaxItems    *int
+	MinLength   *int
+	MaxLength   *int
+	Minimum     *float64
+	Maximum     *float64
+	Example     any
+}
+
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// Parse reads an OpenAPI 3 YAML document. $refs must be local (#/...).
+func Parse(data []byte) (*Spec, error) {
+	document, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := document.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("openapi: document is not a mapping")
+	}
+	if version, _ := root["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("openapi: unsupported version %v (expected 3.x)", root["openapi"])
+	}
+
+	b := &builder{root: root, schemas: map[string]*Schema{}}
+	spec := &Spec{Raw: data}
+	info, _ := root["info"].(map[string]any)
+	spec.Title, spec.Description, spec.Version = text(info, "title"), text(info, "description"), text(info, "version")
+
+	paths, _ := root["paths"].(map[string]any)
+	for _, path := range sortedKeys(paths) {
+		item, _ := b.resolve(paths[path]).(map[string]any)
+		shared, err := b.parameters(item["parameters"], path)
+		if err != nil {
+			return nil, err
+		}
+		for _, method := range methods {
+			node, ok := item[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			operation, err := b.operation(strings.ToUpper(method), path, node, shared)
+			if err != nil {
+				return nil, err
+			}
+			spec.Operations = append(spec.Operations, operation)
+		}
+	}
+
+	components, _ := root["components"].(map[string]any)
+	schemas, _ := components["schemas"].(map[string]any)
+	for _, name := range sortedKeys(schemas) {
+		schema, err := b.schema(map[string]any{"$ref": "#/components/schemas/" + name}, name)
+		if err != nil {
+			return nil, err
+		}
+		spec.Schemas = append(spec.Schemas, schema)
+	}
+	return spec, nil
+}
+
+// Find returns the operation for a request path, with its path parameters.
+// HEAD falls back to GET.
+func (s *Spec) Find(method, path string) (*Operation, map[string]string) {
+	for _, candidate := range []string{method, http.MethodGet} {
+		for _, operation := range s.Operations {
+			if operation.Method != candidate {
+				continue
+			}
+			if params, ok := matchPath(operation.Path, path); ok {
+				return operation, params
+			}
+		}
+		if method != http.MethodHead {
+			break
+		}
+	}
+	return nil, nil
+}
+
+// matchPath matches /receipts/{id}/points against a concrete path
+func matchPath(template, path string) (map[string]string, bool) {
+	templateSegments := strings.Split(strings.Trim(template, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(templateSegments) != len(pathSegments) || strings.HasSuffix(template, "/") != strings.HasSuffix(path, "/") {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, segment := range templateSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = pathSegments[i]
+		} else if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// Response picks the documented response for a status: exact, then 4XX, then default
+func (o *Operation) Response(status int) *Response {
+	code := strconv.Itoa(status)
+	for _, candidate := range []string{code, code[:1] + "XX", "default"} {
+		for _, response := range o.Responses {
+			if strings.EqualFold(response.Status, candidate) {
+				return response
+			}
+		}
+	}
+	return nil
+}
+
+type builder struct {
+	root    map[string]any
+	schemas map[string]*Schema // by $ref, so recursive schemas terminate
+}
+
+// resolve follows a local $ref (#/a/b) to the node it points at
+func (b *builder) resolve(node any) any {
+	for i := 0; i < 32; i++ {
+		mapping, ok := node.(map[string]any)
+		if !ok {
+			return node
+		}
+		ref, ok := mapping["$ref"].(string)
+		if !ok {
+			return node
+		}
+		node = b.lookup(ref)
+	}
+	return nil
+}
+
+func (b *builder) lookup(ref string) any {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var node any = b.root
+	for _, segment := range strings.Split(ref[2:], "/") {
+		segment = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+		mapping, ok := node.(map[string]any)
+		if !ok {
+			return nil
+		}
+		node = mapping[segment]
+	}
+	return node
+}
+
+func (b *builder) operation(method, path string, node map[string]any, shared []*Parameter) (*Operation, error) {
+	operation := &Operation{
+		Method:      method,
+		Path:        path,
+		Summary:     text(node, "summary"),
+		Description: text(node, "description"),
+	}
+	where := method + " " + path
+
+	own, err := b.parameters(node["parameters"], where)
+	if err != nil {
+		return nil, err
+	}
+	// operation parameters override path-level ones with the same name + location
+	operation.Parameters = own
+	for _, parameter := range shared {
+		overridden := false
+		for _, o := range own {
+			overridden = overridden || (o.Name == parameter.Name && o.In == parameter.In)
+		}
+		if !overridden {
+			operation.Parameters = append(operation.Parameters, parameter)
+		}
+	}
+
+	if body, ok := b.resolve(node["requestBody"]).(map[string]any); ok {
+		required, _ := body["required"].(bool)
+		content, err := b.content(body["content"], where+" requestBody")
+		if err != nil {
+			return nil, err
+		}
+		operation.RequestBody = &RequestBody{Required: required, Content: content}
+	}
+
+	responses, _ := node["responses"].(map[string]any)
+	for _, status := range sortedKeys(responses) {
+		response, _ := b.resolve(responses[status]).(map[string]any)
+		content, err := b.content(response["content"], where+" response "+status)
+		if err != nil {
+			return nil, err
+		}
+		operation.Responses = append(operation.Responses, &Response{Status: status, Description: text(response, "description"), Content: content})
+	}
+	return operation, nil
+}
+
+func (b *builder) parameters(node any, where string) ([]*Parameter, error) {
+	list, _ := node.([]any)
+	var parameters []*Parameter
+	for _, item := range list {
+		mapping, ok := b.resolve(item).(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("openapi: %s: parameter is not a mapping", where)
+		}
+		required, _ := mapping["required"].(bool)
+		parameter := &Parameter{Name: text(mapping, "name"), In: text(mapping, "in"), Required: required, Description: text(mapping, "description")}
+		if mapping["schema"] != nil {
+			schema, err := b.schema(mapping["schema"], "")
+			if err != nil {
+				return nil, fmt.Errorf("openapi: %s: parameter %s: %v", where, parameter.Name, err)
+			}
+			parameter.Schema = schema
+		}
+		parameters = append(parameters, parameter)
+	}
+	return parameters, nil
+}
+
+func (b *builder) content(node any, where string) (map[string]*Schema, error) {
+	mapping, ok := node.(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	content := map[string]*Schema{}
+	for mediaType, value := range mapping {
+		media, _ := value.(map[string]any)
+		var schema *Schema
+		if media["schema"] != nil {
+			var err error
+			if schema, err = b.schema(media["schema"], ""); err != nil {
+				return nil, fmt.Errorf("openapi: %s %s: %v", where, mediaType, err)
+			}
+		}
+		content[mediaType] = schema
+	}
+	return content, nil
+}
+
+func (b *builder) schema(node any, name string) (*Schema, error) {
+	mapping, ok := node.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("schema is not a mapping")
+	}
+	if ref, ok := mapping["$ref"].(string); ok {
+		if schema, ok := b.schemas[ref]; ok {
+			return schema, nil
+		}
+		target, ok := b.lookup(ref).(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolved $ref %q", ref)
+		}
+		schema := &Schema{Name: ref[strings.LastIndex(ref, "/")+1:]}
+		b.schemas[ref] = schema
+		return schema, b.fill(schema, target)
+	}
+	schema := &Schema{Name: name}
+	return schema, b.fill(schema, mapping)
+}
+
+func (b *builder) fill(schema *Schema, node map[string]any) error {
+	schema.Type, schema.Format, schema.Description = text(node, "type"), text(node, "format"), text(node, "description")
+	schema.Example = node["example"]
+	schema.Enum, _ = node["enum"].([]any)
+
+	if pattern, ok := node["pattern"].(string); ok {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		schema.Pattern = compiled
+	}
+	if required, ok := node["required"].([]any); ok {
+		for _, name := range required {
+			schema.Required = append(schema.Required, fmt.Sprint(name))
+		}
+	}
+	schema.MinItems, schema.MaxItems = integer(node, "minItems"), integer(node, "maxItems")
+	schema.MinLength, schema.MaxLength = integer(node, "minLength"), integer(node, "maxLength")
+	schema.Minimum, schema.Maximum = number(node, "minimum"), number(node, "maximum")
+
+	if properties, ok := node["properties"].(map[string]any); ok {
+		schema.Properties = map[string]*Schema{}
+		for name, property := range properties {
+			child, err := b.schema(property, "")
+			if err != nil {
+				return fmt.Errorf("property %s: %v", name, err)
+			}
+			schema.Properties[name] = child
+		}
+	}
+	if items, ok := node["items"]; ok {
+		child, err := b.schema(items, "")
+		if err != nil {
+			return fmt.Errorf("items: %v", err)
+		}
+		schema.Items = child
+	}
+	return nil
+}
+
+// PropertyNames lists the schema's properties, required ones first
+func (s *Schema) PropertyNames() []string {
+	names := make([]string, 0, len(s.Properties))
+	for _, name := range s.Required {
+		if _, ok := s.Properties[name]; ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range sortedKeys(s.Properties) {
+		if !s.IsRequired(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *Schema) IsRequired(name string) bool {
+	for _, required := range s.Required {
+		if required == name {
+			return true
+		}
+	}
+	return false
+}
+
+func text(node map[string]any, key string) string {
+	if value, ok := node[key]; ok && value != nil {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+func integer(node map[string]any, key string) *int {
+	if value, ok := node[key].(int64); ok {
+		i := int(value)
+		return &i
+	}
+	return nil
+}
+
+func number(node map[string]any, key string) *float64 {
+	switch value := node[key].(type) {
+	case int64:
+		f := float64(value)
+		return &f
+	case float64:
+		return &value
+	}
+	return nil
+}
+
+func sortedKeys[V any](mapping map[string]V) []string {
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
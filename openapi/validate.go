@@ -0,0 +1,274 @@
+// openapi/validate.go
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mismatch is one way a request or response differs from the spec.
+// Pointer is a JSON pointer into the body, or names the parameter/header.
+type Mismatch struct {
+	Pointer string
+	Message string
+}
+
+func (m Mismatch) String() string {
+	if m.Pointer == "" {
+		return m.Message
+	}
+	return m.Pointer + ": " + m.Message
+}
+
+// ValidateRequest checks path/query parameters and the body against the operation.
+// A nil body means it was not available and is not checked.
+func (o *Operation) ValidateRequest(r *http.Request, pathParams map[string]string, body []byte) []Mismatch {
+	var mismatches []Mismatch
+	query := r.URL.Query()
+	for _, parameter := range o.Parameters {
+		var value string
+		var present bool
+		switch parameter.In {
+		case "path":
+			value, present = pathParams[parameter.Name]
+		case "query":
+			present = query.Has(parameter.Name)
+			value = query.Get(parameter.Name)
+		case "header":
+			value = r.Header.Get(parameter.Name)
+			present = value != ""
+		default:
+			continue
+		}
+		where := parameter.In + " parameter " + parameter.Name
+		if !present {
+			if parameter.Required {
+				mismatches = append(mismatches, Mismatch{where, "is required"})
+			}
+			continue
+		}
+		if parameter.Schema != nil {
+			for _, m := range parameter.Schema.validate(parameterValue(parameter.Schema, value), "") {
+				mismatches = append(mismatches, Mismatch{where, m.Message})
+			}
+		}
+	}
+
+	if o.RequestBody == nil || body == nil || r.Method == http.MethodHead {
+		return mismatches
+	}
+	if len(body) == 0 {
+		if o.RequestBody.Required {
+			mismatches = append(mismatches, Mismatch{"", "request body is required"})
+		}
+		return mismatches
+	}
+	return append(mismatches, validateContent(o.RequestBody.Content, r.Header.Get("Content-Type"), body)...)
+}
+
+// ValidateResponse checks that the status is documented and the body matches its schema
+func (o *Operation) ValidateResponse(status int, contentType string, body []byte) []Mismatch {
+	response := o.Response(status)
+	if response == nil {
+		return []Mismatch{{"", fmt.Sprintf("status %d is not documented", status)}}
+	}
+	if len(response.Content) == 0 || len(body) == 0 {
+		return nil
+	}
+	return validateContent(response.Content, contentType, body)
+}
+
+func validateContent(content map[string]*Schema, contentType string, body []byte) []Mismatch {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/json"
+	}
+	schema, ok := content[mediaType]
+	if !ok {
+		documented := sortedKeys(content)
+		return []Mismatch{{"", fmt.Sprintf("content type %s is not documented (expected %s)", mediaType, strings.Join(documented, ", "))}}
+	}
+	if schema == nil || !strings.HasSuffix(mediaType, "json") {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return []Mismatch{{"", fmt.Sprintf("body is not valid JSON: %v", err)}}
+	}
+	return schema.Validate(value)
+}
+
+// Validate checks a decoded JSON value (json.Number for numbers) against the schema
+func (s *Schema) Validate(value any) []Mismatch {
+	return s.validate(value, "")
+}
+
+func (s *Schema) validate(value any, pointer string) []Mismatch {
+	var mismatches []Mismatch
+	fail := func(format string, args ...any) {
+		mismatches = append(mismatches, Mismatch{pointer, fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type != "" && !matchesType(s.Type, value) {
+		fail("expected %s, got %s", s.Type, typeName(value))
+		return mismatches
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		fail("must be one of %v", s.Enum)
+	}
+
+	switch value := value.(type) {
+	case string:
+		if s.Pattern != nil && !s.Pattern.MatchString(value) {
+			fail("%q does not match pattern %s", value, s.Pattern)
+		}
+		if s.MinLength != nil && len([]rune(value)) < *s.MinLength {
+			fail("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && len([]rune(value)) > *s.MaxLength {
+			fail("must be at most %d characters", *s.MaxLength)
+		}
+		if err := checkFormat(s.Format, value); err != nil {
+			fail("%v", err)
+		}
+	case json.Number:
+		if f, err := value.Float64(); err == nil {
+			if s.Minimum != nil && f < *s.Minimum {
+				fail("must be at least %v", *s.Minimum)
+			}
+			if s.Maximum != nil && f > *s.Maximum {
+				fail("must be at most %v", *s.Maximum)
+			}
+		}
+	case []any:
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(value) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range value {
+				mismatches = append(mismatches, s.Items.validate(item, pointer+"/"+strconv.Itoa(i))...)
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				mismatches = append(mismatches, Mismatch{pointer + "/" + name, "is required"})
+			}
+		}
+		for _, name := range sortedKeys(value) {
+			if property, ok := s.Properties[name]; ok {
+				mismatches = append(mismatches, property.validate(value[name], pointer+"/"+escapePointer(name))...)
+			}
+		}
+	}
+	return mismatches
+}
+
+func matchesType(schemaType string, value any) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := number.Int64()
+		return err == nil
+	}
+	return true
+}
+
+func typeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func inEnum(enum []any, value any) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) || reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFormat knows the formats api.yml uses; others are not checked
+func checkFormat(format, value string) error {
+	switch format {
+	case "date":
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return fmt.Errorf("%q is not a date (YYYY-MM-DD)", value)
+		}
+	case "time":
+		// api.yml describes purchaseTime as 24-hour HH:MM
+		if _, err := time.Parse("15:04", value); err != nil {
+			if _, err := time.Parse("15:04:05", value); err != nil {
+				return fmt.Errorf("%q is not a 24-hour time (HH:MM)", value)
+			}
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("%q is not an RFC 3339 date-time", value)
+		}
+	}
+	return nil
+}
+
+// parameterValue converts a raw path/query string into what the schema expects
+func parameterValue(schema *Schema, raw string) any {
+	switch schema.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(raw, 64); err == nil {
+			return json.Number(raw)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	}
+	return raw
+}
+
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,46 @@
+// openapi/yaml.go
+package openapi
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAML decodes a YAML document with gopkg.in/yaml.v3 into the shapes the
+// builder walks: mappings become map[string]any, sequences []any and integers
+// int64. Anchors, aliases and flow collections are resolved by the decoder.
+func parseYAML(data []byte) (any, error) {
+	var document any
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return normalizeYAML(document), nil
+}
+
+func normalizeYAML(node any) any {
+	switch node := node.(type) {
+	case map[string]any:
+		for key, value := range node {
+			node[key] = normalizeYAML(value)
+		}
+		return node
+	case map[any]any:
+		// a mapping with non-string keys, e.g. an unquoted 200: response
+		mapping := make(map[string]any, len(node))
+		for key, value := range node {
+			mapping[fmt.Sprint(key)] = normalizeYAML(value)
+		}
+		return mapping
+	case []any:
+		for i, value := range node {
+			node[i] = normalizeYAML(value)
+		}
+		return node
+	case int:
+		return int64(node)
+	case uint64:
+		return int64(node)
+	}
+	return node
+}